@@ -0,0 +1,354 @@
+package relay
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/sirupsen/logrus"
+)
+
+// default and largest page sizes of api lists
+var (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// apiError the json body of every api error
+type apiError struct {
+	Status  int    `json:"status"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// httpErrorHandler writes errors as an apiError. Errors that are not an echo.HTTPError are
+// internal server errors. Server errors are logged and only answer with their status text,
+// so database and file system details do not reach the client
+func httpErrorHandler(err error, c echo.Context) {
+	status, message := http.StatusInternalServerError, err.Error()
+	if he, ok := err.(*echo.HTTPError); ok {
+		status = he.Code
+		if m, ok := he.Message.(string); ok {
+			message = m
+		} else if m, ok := he.Message.(error); ok {
+			message = m.Error()
+		}
+	}
+	if c.Response().Committed {
+		return
+	}
+	if status >= http.StatusInternalServerError {
+		logrus.Errorf("%s %s: %+v", c.Request().Method, c.Request().URL.Path, err)
+		message = http.StatusText(status)
+	}
+	if err := c.JSON(status, &apiError{Status: status, Title: http.StatusText(status), Message: message}); err != nil {
+		logrus.Error(err)
+	}
+}
+
+// DagTask a task in the api dag detail and the serialized dag
+type DagTask struct {
+	TaskID         string                 `json:"taskID"`
+	Operator       string                 `json:"operator"`
+	Pool           string                 `json:"pool"`
+	PriorityWeight int                    `json:"priorityWeight"`
+	WeightRule     WeightRule             `json:"weightRule,omitempty"`
+	Retries        int                    `json:"retries"`
+	Params         map[string]interface{} `json:"params,omitempty"`
+	Upstream       []string               `json:"upstream"`
+	Downstream     []string               `json:"downstream"`
+}
+
+// DagDetail a dag and its task graph in the api
+type DagDetail struct {
+	DagID            string     `json:"dagID"`
+	Description      string     `json:"description"`
+	ScheduleInterval string     `json:"scheduleInterval"`
+	Timezone         string     `json:"timezone"`
+	IsPaused         bool       `json:"isPaused"`
+	NextRun          *time.Time `json:"nextRun"`
+	Concurrency      int        `json:"concurrency"`
+	MaxActiveRuns    int        `json:"maxActiveRuns"`
+	Hash             string     `json:"hash"`
+	Tasks            []*DagTask `json:"tasks"`
+}
+
+// DagVersionDetail a version of the structure of a dag in the api
+type DagVersionDetail struct {
+	Hash      string         `json:"hash"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Dag       *SerializedDag `json:"dag,omitempty"`
+}
+
+// taskIDs the ids of a list of tasks, sorted
+func taskIDs(tasks []TaskInterface) []string {
+	ids := []string{}
+	for _, t := range tasks {
+		ids = append(ids, t.GetID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// dagDetail describes a dag for the api
+func dagDetail(dag *DAG) *DagDetail {
+	detail := &DagDetail{
+		DagID:            dag.ID,
+		Description:      dag.Description,
+		ScheduleInterval: dag.ScheduleInterval,
+		Timezone:         dag.Location().String(),
+		Concurrency:      dag.concurrency(),
+		MaxActiveRuns:    dag.maxActiveRuns(),
+		Hash:             dag.hash,
+		Tasks:            []*DagTask{},
+	}
+	if dagModel, err := models.GetDag(dag.ID); err == nil {
+		detail.IsPaused = dagModel.IsPaused
+	}
+	if next, err := dag.NextRun(); err == nil && !next.IsZero() {
+		next = next.In(dag.Location())
+		detail.NextRun = &next
+	}
+	for _, taskID := range dag.taskIDs() {
+		task := serializeTask(dag.tasks[taskID])
+		task.PriorityWeight = priorityWeight(dag.tasks[taskID])
+		detail.Tasks = append(detail.Tasks, task)
+	}
+	return detail
+}
+
+// pageParams reads the limit and offset query params
+func pageParams(c echo.Context) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	if l := c.QueryParam("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 || limit > maxPageLimit {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+		}
+	}
+	if o := c.QueryParam("offset"); o != "" {
+		if offset, err = strconv.Atoi(o); err != nil || offset < 0 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "offset must not be negative")
+		}
+	}
+	return limit, offset, nil
+}
+
+// stateParam reads a comma separated list of states from a query param
+func stateParam(c echo.Context) ([]state.State, error) {
+	states := []state.State{}
+	for _, s := range strings.Split(c.QueryParam("state"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !state.Valid(state.State(s)) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "unknown state: "+s)
+		}
+		states = append(states, state.State(s))
+	}
+	return states, nil
+}
+
+// dateParam reads an RFC 3339 date from a query param. Zero if the param is not set
+func dateParam(c echo.Context, name string) (time.Time, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, name+" must be an RFC 3339 date")
+	}
+	return t.UTC(), nil
+}
+
+// lookupDag gets the dag from the id path param
+func (w *Webserver) lookupDag(c echo.Context) (*DAG, error) {
+	dag, ok := w.Dags.Get(c.Param("id"))
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "dag not found")
+	}
+	return dag, nil
+}
+
+// lookupDagRunModel gets the dag and dag run from the id and run path params. Unlike
+// lookupDagRun it does not need a running dag runner
+func (w *Webserver) lookupDagRunModel(c echo.Context) (*DAG, *models.DagRun, error) {
+	dag, err := w.lookupDag(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	runID, err := strconv.Atoi(c.Param("run"))
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "run must be a dag run id")
+	}
+	dagRun, err := models.GetDagRun(runID)
+	if err != nil || dagRun.DagID != dag.ID {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "dag run not found")
+	}
+	return dag, dagRun, nil
+}
+
+// v1Routes applies the version 1 api routes
+func (w *Webserver) v1Routes(group *echo.Group) {
+	group.GET("/dags", func(c echo.Context) error {
+		details := []*DagDetail{}
+		for _, dag := range w.Dags.List() {
+			if w.canView(c, dag.ID) {
+				details = append(details, dagDetail(dag))
+			}
+		}
+		return c.JSON(http.StatusOK, details)
+	})
+	group.GET("/dags/:id", func(c echo.Context) error {
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, dagDetail(dag))
+	})
+	group.PATCH("/dags/:id", func(c echo.Context) error {
+		req := &struct {
+			IsPaused *bool `json:"isPaused"`
+		}{}
+		if err := c.Bind(req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		if req.IsPaused != nil {
+			if err := models.SetDagPaused(dag.ID, *req.IsPaused); err != nil {
+				return err
+			}
+		}
+		return c.JSON(http.StatusOK, dagDetail(dag))
+	}, w.requireRole(models.RoleOperator))
+	group.POST("/dags/:id/runs", func(c echo.Context) error {
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		if w.Runner == nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "dag runner not started")
+		}
+		executionDate := time.Now().UTC()
+		w.Runner.ScheduleDag(dag, executionDate)
+		return c.JSON(http.StatusAccepted, map[string]interface{}{"dagID": dag.ID, "executionDate": executionDate.In(dag.Location())})
+	}, w.requireRole(models.RoleOperator))
+	group.GET("/dags/:id/versions", func(c echo.Context) error {
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		versions, err := models.ListDagVersions(dag.ID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		details := []*DagVersionDetail{}
+		for _, version := range versions {
+			details = append(details, &DagVersionDetail{Hash: version.Hash, CreatedAt: version.CreatedAt.In(dag.Location())})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"dagVersions": details, "totalEntries": len(details)})
+	})
+	group.GET("/dags/:id/versions/:hash", func(c echo.Context) error {
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		version, err := models.GetDagVersion(dag.ID, c.Param("hash"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "dag version not found")
+		}
+		detail := &DagVersionDetail{Hash: version.Hash, CreatedAt: version.CreatedAt.In(dag.Location()), Dag: &SerializedDag{}}
+		if err := json.Unmarshal([]byte(version.Structure), detail.Dag); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, detail)
+	})
+	group.GET("/dags/:id/runs", func(c echo.Context) error {
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		filter := models.DagRunFilter{DagID: dag.ID}
+		if filter.Limit, filter.Offset, err = pageParams(c); err != nil {
+			return err
+		}
+		if filter.States, err = stateParam(c); err != nil {
+			return err
+		}
+		if filter.ExecutionDateGTE, err = dateParam(c, "execution_date_gte"); err != nil {
+			return err
+		}
+		if filter.ExecutionDateLTE, err = dateParam(c, "execution_date_lte"); err != nil {
+			return err
+		}
+		dagRuns, total, err := models.FindDagRuns(filter)
+		if err != nil {
+			return err
+		}
+		for i, dagRun := range dagRuns {
+			dagRuns[i] = dagRun.In(dag.Location())
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"dagRuns": dagRuns, "totalEntries": total})
+	})
+	group.GET("/dags/:id/runs/:run", func(c echo.Context) error {
+		dag, dagRun, err := w.lookupDagRunModel(c)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, dagRun.In(dag.Location()))
+	})
+	group.GET("/dags/:id/runs/:run/tasks", func(c echo.Context) error {
+		dag, dagRun, err := w.lookupDagRunModel(c)
+		if err != nil {
+			return err
+		}
+		filter := models.TaskInstanceFilter{DagRunID: dagRun.ID}
+		if filter.Limit, filter.Offset, err = pageParams(c); err != nil {
+			return err
+		}
+		if filter.States, err = stateParam(c); err != nil {
+			return err
+		}
+		if filter.StartDateGTE, err = dateParam(c, "start_date_gte"); err != nil {
+			return err
+		}
+		if filter.StartDateLTE, err = dateParam(c, "start_date_lte"); err != nil {
+			return err
+		}
+		taskInstances, total, err := models.FindTaskInstances(filter)
+		if err != nil {
+			return err
+		}
+		for i, ti := range taskInstances {
+			taskInstances[i] = ti.In(dag.Location())
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"taskInstances": taskInstances, "totalEntries": total})
+	})
+	group.GET("/dags/:id/runs/:run/tasks/:task", func(c echo.Context) error {
+		dag, dagRun, err := w.lookupDagRunModel(c)
+		if err != nil {
+			return err
+		}
+		ti, err := models.GetTaskInstance(dagRun.ID, c.Param("task"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "task instance not found")
+		}
+		tries, err := ti.Tries()
+		if err != nil {
+			return err
+		}
+		for i, try := range tries {
+			tries[i] = try.In(dag.Location())
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{"taskInstance": ti.In(dag.Location()), "tries": tries})
+	})
+}
@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// apiGet sends a get request to the api routes and decodes the json response into out
+func apiGet(t *testing.T, w *Webserver, path string, out interface{}) int {
+	e := echo.New()
+	w.Routes(e)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if out != nil {
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), out), rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestAPIV1(t *testing.T) {
+	migrate(t)
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	dag.ID = "api-" + time.Now().Format(time.RFC3339Nano)
+	assert.Nil(t, dag.Chain(tasks...))
+	w := NewWebserver(NewDagBag(dag))
+
+	detail := &DagDetail{}
+	assert.Equal(t, http.StatusOK, apiGet(t, w, "/api/v1/dags/"+dag.ID, detail))
+	assert.Len(t, detail.Tasks, 3)
+	assert.Equal(t, []string{"t1"}, detail.Tasks[1].Upstream)
+	assert.Equal(t, []string{"t3"}, detail.Tasks[1].Downstream)
+
+	start := time.Now().UTC().Truncate(time.Hour)
+	for i, s := range []state.State{state.Success, state.Failed, state.Success} {
+		dagRun := dag.DagRunAt(start.Add(time.Duration(i) * time.Hour))
+		dagRun.State = s
+		assert.Nil(t, dagRun.Create())
+	}
+	runs := &struct {
+		DagRuns      []*models.DagRun
+		TotalEntries int
+	}{}
+	path := fmt.Sprintf("/api/v1/dags/%s/runs?state=success&limit=1&offset=1", dag.ID)
+	assert.Equal(t, http.StatusOK, apiGet(t, w, path, runs))
+	assert.Equal(t, 2, runs.TotalEntries)
+	assert.Len(t, runs.DagRuns, 1)
+	assert.True(t, runs.DagRuns[0].ExecutionDate.Equal(start), "newest first")
+
+	path = fmt.Sprintf("/api/v1/dags/%s/runs?execution_date_gte=%s", dag.ID, start.Add(time.Hour).Format(time.RFC3339))
+	assert.Equal(t, http.StatusOK, apiGet(t, w, path, runs))
+	assert.Equal(t, 2, runs.TotalEntries)
+
+	dagRun := runs.DagRuns[0]
+	ti := &models.TaskInstance{TaskID: "t1", DagRunID: dagRun.ID, MaxTries: 2}
+	assert.Nil(t, ti.Create())
+	for _, s := range []state.State{state.Failed, state.Success} {
+		assert.Nil(t, ti.Start())
+		ti.State = s
+		assert.Nil(t, ti.Stop())
+	}
+	taskDetail := &struct {
+		TaskInstance *models.TaskInstance
+		Tries        []*models.TaskTry
+	}{}
+	path = fmt.Sprintf("/api/v1/dags/%s/runs/%d/tasks/t1", dag.ID, dagRun.ID)
+	assert.Equal(t, http.StatusOK, apiGet(t, w, path, taskDetail))
+	assert.Equal(t, 2, taskDetail.TaskInstance.TryNumber)
+	if assert.Len(t, taskDetail.Tries, 2) {
+		assert.Equal(t, state.Failed, taskDetail.Tries[0].State)
+		assert.Equal(t, 2, taskDetail.Tries[1].TryNumber)
+	}
+
+	apiErr := &apiError{}
+	assert.Equal(t, http.StatusNotFound, apiGet(t, w, "/api/v1/dags/missing", apiErr))
+	assert.Equal(t, "dag not found", apiErr.Message)
+	path = fmt.Sprintf("/api/v1/dags/%s/runs?state=bogus", dag.ID)
+	assert.Equal(t, http.StatusBadRequest, apiGet(t, w, path, apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.Status)
+}
+
+func TestAPIServerErrorMessage(t *testing.T) {
+	e := echo.New()
+	for _, err := range []error{
+		errors.New("open /var/lib/relay/relay.db: permission denied"),
+		echo.NewHTTPError(http.StatusServiceUnavailable, "dial tcp 10.0.0.5:3306: connection refused"),
+	} {
+		rec := httptest.NewRecorder()
+		httpErrorHandler(err, e.NewContext(httptest.NewRequest(http.MethodGet, "/api/v1/dags", nil), rec))
+		apiErr := &apiError{}
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), apiErr))
+		assert.Equal(t, http.StatusText(rec.Code), apiErr.Message, "%v reached the client", err)
+	}
+}
@@ -0,0 +1,255 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// sessionCookie name of the cookie holding a webserver login session
+const sessionCookie = "relay_session"
+
+// userKey echo context key of the user a request was authenticated as
+const userKey = "user"
+
+// sessionLifetime how long a login session lasts
+var sessionLifetime = 12 * time.Hour
+
+var (
+	sessionKeyOnce sync.Once
+	sessionKey     []byte
+)
+
+// authEnabled checks if the webserver requires users to log in
+func authEnabled() bool {
+	return config.DefaultConfig.Webserver.Authenticate
+}
+
+// sessionSigningKey the key sessions are signed with. It is the webserver secret key, or a
+// random key when none is set so sessions end when the webserver stops
+func sessionSigningKey() []byte {
+	if secret := config.DefaultConfig.Webserver.SecretKey; secret != "" {
+		return []byte(secret)
+	}
+	sessionKeyOnce.Do(func() {
+		logrus.Warn("no secret_key in the webserver config, login sessions end when the webserver stops")
+		sessionKey = make([]byte, 32)
+		if _, err := rand.Read(sessionKey); err != nil {
+			logrus.Fatal(errors.Wrap(err, "session key"))
+		}
+	})
+	return sessionKey
+}
+
+// signSession signs a session payload
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, sessionSigningKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSession creates a signed session for a user that ends at expires
+func newSession(username string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(username)) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + signSession(payload)
+}
+
+// parseSession checks the signature and expiry of a session and gets its username
+func parseSession(session string, now time.Time) (string, error) {
+	i := strings.LastIndex(session, ".")
+	if i < 0 {
+		return "", errors.New("malformed session")
+	}
+	payload, signature := session[:i], session[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(signSession(payload))) {
+		return "", errors.New("invalid session signature")
+	}
+	parts := strings.Split(payload, ".")
+	if len(parts) != 2 {
+		return "", errors.New("malformed session")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return "", errors.New("session expired")
+	}
+	username, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.New("malformed session")
+	}
+	return string(username), nil
+}
+
+// requestUser gets the user from the bearer api token or the session cookie of a request.
+// Returns nil if the request has neither or they do not match a user
+func requestUser(c echo.Context) *models.User {
+	if token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); token != "" {
+		if user, err := models.GetUserByToken(token); err == nil {
+			return user
+		}
+		return nil
+	}
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	username, err := parseSession(cookie.Value, time.Now())
+	if err != nil {
+		return nil
+	}
+	user, err := models.GetUser(username)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// publicPaths routes that do not need a user. The kill endpoint also takes the secret key,
+// the health check is for supervisors and the metrics are for scrapers
+var publicPaths = map[string]bool{
+	"/login":       true,
+	"/public/*":    true,
+	"/favicon.ico": true,
+	"/api/kill":    true,
+	"/health":      true,
+	"/metrics":     true,
+}
+
+// authenticate sets the user of a request when authentication is on. Api requests without
+// a user are refused and page requests are sent to the login page
+func (w *Webserver) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !authEnabled() {
+			return next(c)
+		}
+		if user := requestUser(c); user != nil {
+			c.Set(userKey, user)
+			return next(c)
+		}
+		if publicPaths[c.Path()] {
+			return next(c)
+		}
+		if strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			return echo.NewHTTPError(http.StatusUnauthorized, "log in or send an api token")
+		}
+		return c.Redirect(http.StatusFound, "/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+	}
+}
+
+// userRole the role of the request's user on a dag, or the user's own role for a nil dag.
+// Every request is an admin when authentication is off
+func userRole(c echo.Context, dag *DAG) models.Role {
+	if !authEnabled() {
+		return models.RoleAdmin
+	}
+	user, ok := c.Get(userKey).(*models.User)
+	if !ok {
+		return models.RoleNone
+	}
+	if dag == nil {
+		return user.Role
+	}
+	return dag.role(user)
+}
+
+// role the role of a user on the dag. Dags with access control can only be seen by admins
+// and the users listed in it, who have the role listed for them
+func (d *DAG) role(user *models.User) models.Role {
+	if user.Role == models.RoleAdmin || len(d.AccessControl) == 0 {
+		return user.Role
+	}
+	return models.Role(d.AccessControl[user.Username])
+}
+
+// authorize checks the request's user has the required role on a dag, or on its own for a
+// nil dag
+func authorize(c echo.Context, dag *DAG, required models.Role) error {
+	role := userRole(c, dag)
+	if role.Allows(required) {
+		return nil
+	}
+	if role == models.RoleNone && dag != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "dag not found")
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "requires the "+string(required)+" role")
+}
+
+// requireRole refuses requests whose user does not have the required role on the dag of
+// the id param, or on its own for routes without a dag
+func (w *Webserver) requireRole(required models.Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var dag *DAG
+			if dagID := c.Param("id"); dagID != "" {
+				dag, _ = w.Dags.Get(dagID)
+			}
+			if err := authorize(c, dag, required); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// canView checks if the request's user can see a dag by id
+func (w *Webserver) canView(c echo.Context, dagID string) bool {
+	dag, _ := w.Dags.Get(dagID)
+	return authorize(c, dag, models.RoleViewer) == nil
+}
+
+// authRoutes applies the login and logout routes
+func (w *Webserver) authRoutes(e *echo.Echo) {
+	e.POST("/login", func(c echo.Context) error {
+		req := &struct {
+			Username string `json:"username" form:"username"`
+			Password string `json:"password" form:"password"`
+			Next     string `json:"next" form:"next"`
+		}{}
+		if err := c.Bind(req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		form := strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEApplicationForm)
+		user, err := models.GetUser(req.Username)
+		if err != nil || !user.CheckPassword(req.Password) {
+			logrus.Warnf("failed login for %q from %s", req.Username, c.RealIP())
+			if form {
+				return c.Redirect(http.StatusSeeOther, "/login?failed=1&next="+url.QueryEscape(req.Next))
+			}
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
+		}
+		expires := time.Now().Add(sessionLifetime)
+		c.SetCookie(&http.Cookie{
+			Name:     sessionCookie,
+			Value:    newSession(user.Username, expires),
+			Path:     "/",
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   c.IsTLS(),
+			SameSite: http.SameSiteLaxMode,
+		})
+		if form {
+			next := req.Next
+			if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+				next = "/"
+			}
+			return c.Redirect(http.StatusSeeOther, next)
+		}
+		return c.JSON(http.StatusOK, user)
+	})
+	e.POST("/logout", func(c echo.Context) error {
+		c.SetCookie(&http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true})
+		return c.Redirect(http.StatusSeeOther, "/login")
+	})
+}
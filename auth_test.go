@@ -0,0 +1,109 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	now := time.Now()
+	session := newSession("alice", now.Add(time.Hour))
+	username, err := parseSession(session, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", username)
+
+	_, err = parseSession(session, now.Add(2*time.Hour))
+	assert.NotNil(t, err, "expired")
+	forged := newSession("mallory", now.Add(time.Hour))
+	_, err = parseSession(forged[:strings.Index(forged, ".")]+session[strings.Index(session, "."):], now)
+	assert.NotNil(t, err, "username swapped under another signature")
+}
+
+func TestAuthorization(t *testing.T) {
+	migrate(t)
+	authenticate := config.DefaultConfig.Webserver.Authenticate
+	config.DefaultConfig.Webserver.Authenticate = true
+	defer func() { config.DefaultConfig.Webserver.Authenticate = authenticate }()
+
+	suffix := time.Now().Format(time.RFC3339Nano)
+	tokens := map[models.Role]string{}
+	for _, role := range []models.Role{models.RoleViewer, models.RoleOperator, models.RoleAdmin} {
+		user, token, err := models.CreateUser(string(role)+"-"+suffix, "secret", role)
+		assert.Nil(t, err)
+		assert.True(t, user.CheckPassword("secret"))
+		assert.False(t, user.CheckPassword("wrong"))
+		tokens[role] = token
+	}
+	_, _, err := models.CreateUser("nobody-"+suffix, "secret", "root")
+	assert.NotNil(t, err, "unknown role")
+
+	open, _ := newTestDag(t, "t1")
+	open.ID = "open-" + suffix
+	private, _ := newTestDag(t, "t1")
+	private.ID = "private-" + suffix
+	private.AccessControl = map[string]string{"viewer-" + suffix: "operator"}
+	for _, dag := range []*DAG{open, private} {
+		assert.Nil(t, dag.getOrCreateDagModel())
+	}
+	w := NewWebserver(NewDagBag(open, private))
+	e := echo.New()
+	w.Routes(e)
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	pause := `{"isPaused": true}`
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/v1/dags", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/v1/dags", "not-a-token", "").Code)
+	assert.Equal(t, http.StatusFound, request(http.MethodGet, "/dags/"+open.ID, "", "").Code)
+
+	viewer := tokens[models.RoleViewer]
+	rec := request(http.MethodGet, "/api/v1/dags", viewer, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), open.ID)
+	assert.Contains(t, rec.Body.String(), private.ID, "listed in the access control")
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPatch, "/api/v1/dags/"+open.ID, viewer, pause).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPatch, "/api/v1/dags/"+private.ID, viewer, pause).Code, "operator of the private dag")
+
+	operator := tokens[models.RoleOperator]
+	rec = request(http.MethodGet, "/api/v1/dags", operator, "")
+	assert.NotContains(t, rec.Body.String(), private.ID)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/v1/dags/"+private.ID, operator, "").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPatch, "/api/v1/dags/"+open.ID, operator, pause).Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/api/pools", operator, `{"pool": "p", "slots": 1}`).Code)
+
+	admin := tokens[models.RoleAdmin]
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/v1/dags/"+private.ID, admin, "").Code)
+	shutdowns := 0
+	w.Shutdown = func() { shutdowns++ }
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/api/kill", operator, "").Code, "no secret key set")
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/api/kill", admin, "").Code)
+	assert.Equal(t, 1, shutdowns)
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/login", "", `{"username": "operator-`+suffix+`", "password": "wrong"}`).Code)
+	rec = request(http.MethodPost, "/login", "", `{"username": "operator-`+suffix+`", "password": "secret"}`)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/dags/"+open.ID, nil)
+	req.Header.Set("Cookie", rec.Header().Get("Set-Cookie"))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "session cookie")
+
+	assert.Nil(t, models.DeleteUser("operator-"+suffix))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/v1/dags", operator, "").Code)
+}
@@ -2,30 +2,36 @@ package relay
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/estenssoros/relay/models"
-	"github.com/estenssoros/relay/state"
+	"github.com/estenssoros/relay/tracing"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// killTimeout how long a stopped command has to exit before it is killed
+var killTimeout = 10 * time.Second
+
 // BashOperator runs a bash command
 type BashOperator struct {
 	TaskID            string
 	DAG               *DAG `json:"-"` // avoid recursion
 	Retries           int
+	Pool              string
+	PriorityWeight    int
+	WeightRule        WeightRule
 	Message           string
 	BashCommand       string
 	Dir               string
 	upstreamTaskIDs   []string
 	downstreamTaskIDs []string
-	State             state.State
-	model             *models.TaskInstance
 }
 
 func (o BashOperator) String() string {
@@ -68,13 +74,13 @@ func (o *BashOperator) addUpstreamTask(taskID string) {
 }
 
 // SetUpstream creates relationships between tasks
-func (o *BashOperator) SetUpstream(task TaskInterface) {
-	setRelatives(o, task, true)
+func (o *BashOperator) SetUpstream(task TaskInterface) error {
+	return setRelatives(o, task, true)
 }
 
 // SetDownStream creates relationships between tasks
-func (o *BashOperator) SetDownStream(task TaskInterface) {
-	setRelatives(o, task, false)
+func (o *BashOperator) SetDownStream(task TaskInterface) error {
+	return setRelatives(o, task, false)
 }
 
 // hasUpstream returns true if the operators has upstream tasks
@@ -112,18 +118,9 @@ func (o *BashOperator) IsRoot() bool {
 	return !o.hasUpstream()
 }
 
-// SetState sets the state on an operator
-func (o *BashOperator) SetState(s state.State) {
-	o.State = s
-}
-
-// GetState gets the state from an operator
-func (o *BashOperator) GetState() state.State {
-	return o.State
-}
-
-// Run run the bash operator
-func (o *BashOperator) Run() error {
+// Run run the bash operator. When the context is cancelled the command is sent SIGTERM
+// and killed if it has not exited after killTimeout
+func (o *BashOperator) Run(ctx context.Context) error {
 	cmdArgs := strings.Fields(o.BashCommand)
 	if len(cmdArgs) == 0 {
 		return errors.New("bash command had no args")
@@ -138,32 +135,68 @@ func (o *BashOperator) Run() error {
 	if o.Dir != "" {
 		cmd.Dir = o.Dir
 	}
+	cmd.Env = append(os.Environ(), tracing.Env(ctx)...)
 
 	var stderr bytes.Buffer
-	mw := io.MultiWriter(&stderr, os.Stderr)
+	mw := io.MultiWriter(&stderr, os.Stderr, taskLog(ctx))
 	cmd.Stderr = mw
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = io.MultiWriter(os.Stdout, taskLog(ctx))
 
 	err := cmd.Start()
 	if err != nil {
 		return fmt.Errorf("%s\n%s", err, stderr.String())
 	}
 	logrus.Infof("running: %s (PID: %d)", strings.Join(cmd.Args, " "), cmd.Process.Pid)
-	err = cmd.Wait()
-	if err != nil {
+
+	if err := waitCommand(ctx, cmd); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
 		return fmt.Errorf("%s\n%s", err, stderr.String())
 	}
 	return nil
 }
 
+// waitCommand waits for a started command. When the context is cancelled the command is
+// sent SIGTERM and killed if it has not exited after killTimeout
+func waitCommand(ctx context.Context, cmd *exec.Cmd) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		logrus.Warnf("stopping: %s (PID: %d)", strings.Join(cmd.Args, " "), cmd.Process.Pid)
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(killTimeout):
+			cmd.Process.Kill()
+			<-done
+		}
+		return errors.Wrap(ctx.Err(), "interrupted")
+	}
+}
+
 // OperatorType returns the type of the operator
 func (o *BashOperator) OperatorType() string { return `bash` }
 
-// SetModel sets a model to the operator
-func (o *BashOperator) SetModel(m *models.TaskInstance) {
-	o.model = m
-}
+// GetPool gets the pool the operator runs in
+func (o *BashOperator) GetPool() string { return o.Pool }
 
-// GetModel gets the model from the operator
-func (o *BashOperator) GetModel() *models.TaskInstance { return o.model }
+// GetPriorityWeight gets the priority weight of the operator
+func (o *BashOperator) GetPriorityWeight() int { return o.PriorityWeight }
+
+// GetWeightRule gets the weight rule of the operator
+func (o *BashOperator) GetWeightRule() WeightRule { return o.WeightRule }
+
+// GetRetries gets the number of times the operator is retried
+func (o *BashOperator) GetRetries() int { return o.Retries }
+
+// serializeParams the key parameters of the operator in the serialized dag
+func (o *BashOperator) serializeParams() map[string]interface{} {
+	return map[string]interface{}{"bashCommand": o.BashCommand, "dir": o.Dir}
+}
@@ -0,0 +1,138 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+// startSleepingRun runs a dag run of sleep >> after and waits until sleep is running
+func startSleepingRun(t *testing.T, ctx context.Context) (*TaskRunner, <-chan struct{}) {
+	dag, err := NewDag(&DagConfig{ID: "sleeping-" + time.Now().Format(time.RFC3339Nano), ScheduleInterval: "@none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleep, _ := dag.NewBash(&BashOperator{TaskID: "sleep", BashCommand: "sleep 30"})
+	after, _ := dag.NewBash(&BashOperator{TaskID: "after", BashCommand: "date"})
+	assert.Nil(t, dag.Chain(sleep, after))
+
+	queue := NewTaskQueue()
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	runner := NewTaskRunner(dag.tasks, queue)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, dag.Run(ctx, runner, dagRun))
+	}()
+	for {
+		ti, err := models.GetTaskInstance(dagRun.ID, "sleep")
+		if err == nil && ti.State == state.Running {
+			time.Sleep(100 * time.Millisecond) // let the worker start the process
+			return runner, done
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("sleep did not start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestKillTask(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runner, done := startSleepingRun(t, ctx)
+
+	assert.NotNil(t, runner.Kill("after"), "not running")
+	assert.Nil(t, runner.Kill("sleep"))
+	<-done
+	assert.Nil(t, ctx.Err(), "killed before the sleep ended")
+	assert.Equal(t, state.Failed, runner.FinalState())
+	assert.Equal(t, state.Failed, runner.Tasks["sleep"].State)
+	assert.Equal(t, "killed", runner.Tasks["sleep"].Model.Message)
+	assert.Equal(t, state.UpstreamFailed, runner.Tasks["after"].State)
+}
+
+func TestCancelDagRun(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	runner, done := startSleepingRun(t, ctx)
+
+	assert.Nil(t, runner.Cancel())
+	<-done
+	assert.Nil(t, ctx.Err(), "cancelled before the sleep ended")
+	assert.Equal(t, state.Failed, runner.FinalState())
+	assert.Equal(t, state.Failed, runner.Tasks["sleep"].State)
+	assert.Equal(t, state.Skipped, runner.Tasks["after"].State)
+	assert.NotNil(t, runner.Cancel(), "dag run finished")
+}
+
+func TestCancelTakenTask(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dag, err := NewDag(&DagConfig{ID: "taken-" + time.Now().Format(time.RFC3339Nano), ScheduleInterval: "@none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sleep, _ := dag.NewBash(&BashOperator{TaskID: "sleep", BashCommand: "sleep 30"})
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	ti := NewTaskInstance(sleep)
+	ti.Model = &models.TaskInstance{TaskID: "sleep", DagRunID: dagRun.ID, MaxTries: 1}
+	assert.Nil(t, ti.Model.Create())
+
+	assert.False(t, ti.cancel(), "taken by a worker that has not started it")
+	queue := NewTaskQueue()
+	evalQueue := make(chan *TaskInstance, 1)
+	queue.Push(ti, evalQueue)
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	select {
+	case <-evalQueue:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled task ran")
+	}
+	assert.Equal(t, state.Failed, ti.Model.State)
+	assert.Equal(t, "killed", ti.Model.Message)
+}
+
+func TestKillSchedulerRequiresSecret(t *testing.T) {
+	shutdowns := 0
+	w := NewWebserver(NewDagBag())
+	w.Shutdown = func() { shutdowns++ }
+	e := echo.New()
+	w.Routes(e)
+	kill := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/kill", nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	secret := config.DefaultConfig.Webserver.SecretKey
+	defer func() { config.DefaultConfig.Webserver.SecretKey = secret }()
+	config.DefaultConfig.Webserver.SecretKey = ""
+	assert.Equal(t, http.StatusForbidden, kill("anything"))
+
+	config.DefaultConfig.Webserver.SecretKey = "s3cret"
+	assert.Equal(t, http.StatusUnauthorized, kill(""))
+	assert.Equal(t, http.StatusUnauthorized, kill("wrong"))
+	assert.Equal(t, 0, shutdowns)
+	assert.Equal(t, http.StatusAccepted, kill("s3cret"))
+	assert.Equal(t, 1, shutdowns)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/pkg/errors"
+)
+
+// apiURL base url of the running relay webserver
+var apiURL string
+
+// apiTokenEnv holds the api token sent to a webserver that requires users to log in
+const apiTokenEnv = "RELAY_API_TOKEN"
+
+func defaultAPIURL() string {
+	return fmt.Sprintf("http://localhost:%d", config.DefaultConfig.Webserver.Port)
+}
+
+// apiRequest sends a json request to the relay api and decodes the json response into out
+func apiRequest(method, path string, in, out interface{}) error {
+	body := &bytes.Buffer{}
+	if in != nil {
+		if err := json.NewEncoder(body).Encode(in); err != nil {
+			return errors.Wrap(err, "json encode")
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(apiURL, "/")+path, body)
+	if err != nil {
+		return errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv(apiTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "is the relay scheduler running?")
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "read body")
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(b, apiErr) == nil && apiErr.Message != "" {
+			return errors.Errorf("%s: %s", resp.Status, apiErr.Message)
+		}
+		return errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return errors.Wrap(json.Unmarshal(b, out), "json decode")
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var dagRunLimit int
+
+func init() {
+	dagsCmd.AddCommand(dagsRunsCmd)
+}
+
+var dagsCmd = &cobra.Command{
+	Use:   "dags",
+	Short: "inspect dags",
+}
+
+func init() {
+	dagsRunsCmd.Flags().IntVarP(&dagRunLimit, "limit", "n", 25, "number of runs to list")
+}
+
+var dagsRunsCmd = &cobra.Command{
+	Use:   "runs <dag>",
+	Short: "list the latest runs of a dag in the dag's time zone",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dag, err := models.GetDag(args[0])
+		if err != nil {
+			return errors.Wrap(err, "get dag")
+		}
+		loc, err := config.Location(dag.Timezone)
+		if err != nil {
+			return errors.Wrap(err, "dag timezone")
+		}
+		dagRuns, err := models.ListDagRuns(dag.ID, dagRunLimit)
+		if err != nil {
+			return errors.Wrap(err, "list dag runs")
+		}
+		if len(dagRuns) == 0 {
+			fmt.Println("no dag runs present")
+		}
+		for _, dagRun := range dagRuns {
+			dagRun = dagRun.In(loc)
+			fmt.Println(dagRun.ID, dagRun.ExecutionDate.Format("2006-01-02 15:04:05 MST"), dagRun.State)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/estenssoros/relay"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var maxHeartbeatAge time.Duration
+
+func init() {
+	healthCmd.Flags().DurationVarP(&maxHeartbeatAge, "max-age", "", 0, "oldest scheduler heartbeat that is healthy. defaults to three job heartbeats")
+}
+
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "check the relay database and scheduler heartbeat. exits non-zero when unhealthy",
+	// the health json is the output, usage would only bury it
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		health := relay.CheckHealth(nil, maxHeartbeatAge)
+		b, err := json.MarshalIndent(health, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal health")
+		}
+		fmt.Println(string(b))
+		if health.Status != relay.Healthy {
+			return errors.Errorf("relay is unhealthy: %s", health.Problems())
+		}
+		return nil
+	},
+}
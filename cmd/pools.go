@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	poolName        string
+	poolSlots       int
+	poolDescription string
+)
+
+func init() {
+	poolCmd.AddCommand(poolListCmd)
+	poolCmd.AddCommand(poolSetCmd)
+	poolCmd.AddCommand(poolDeleteCmd)
+}
+
+var poolCmd = &cobra.Command{
+	Use:   "pools",
+	Short: "list/set/delete pools",
+}
+
+var poolListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "list pools",
+	PreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pools, err := models.ListPools()
+		if err != nil {
+			return errors.Wrap(err, "list pools")
+		}
+		if len(pools) == 0 {
+			fmt.Println("no pools present")
+		}
+		for _, p := range pools {
+			fmt.Println(p.Pool, p.Slots, p.Description)
+		}
+		return nil
+	},
+}
+
+func init() {
+	poolSetCmd.Flags().StringVarP(&poolName, "pool", "", "", "pool name")
+	poolSetCmd.Flags().IntVarP(&poolSlots, "slots", "", 0, "number of tasks that can run in the pool at once")
+	poolSetCmd.Flags().StringVarP(&poolDescription, "description", "", "", "pool description")
+	poolDeleteCmd.Flags().StringVarP(&poolName, "pool", "", "", "pool name")
+}
+
+var poolSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "create or update a pool",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if poolName == "" {
+			return errors.New("must supply pool")
+		}
+		if poolSlots < 0 {
+			return errors.New("slots must not be negative")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := models.SetPool(poolName, poolSlots, poolDescription); err != nil {
+			return errors.Wrap(err, "set pool")
+		}
+		fmt.Printf("pool %s set to %d slots\n", poolName, poolSlots)
+		return nil
+	},
+}
+
+var poolDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "delete a pool",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if poolName == "" {
+			return errors.New("must supply pool")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := models.DeletePool(poolName); err != nil {
+			return errors.Wrap(err, "delete pool")
+		}
+		fmt.Printf("pool %s deleted\n", poolName)
+		return nil
+	},
+}
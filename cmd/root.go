@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/estenssoros/relay/db"
 	"github.com/spf13/cobra"
 )
 
@@ -26,11 +27,24 @@ func init() {
 	rootCmd.AddCommand(initDBCmd)
 	rootCmd.AddCommand(connectionCmd)
 	rootCmd.AddCommand(webserverCmd)
+	rootCmd.AddCommand(poolCmd)
+	rootCmd.AddCommand(tasksCmd)
+	rootCmd.AddCommand(dagsCmd)
+	rootCmd.AddCommand(usersCmd)
+	rootCmd.AddCommand(healthCmd)
+	rootCmd.AddCommand(workerCmd)
 }
 
 var rootCmd = &cobra.Command{
 	Use:   "relay",
 	Short: "",
+	// every command but health needs the relay database, health reports it unhealthy
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if cmd == healthCmd {
+			return nil
+		}
+		return db.Err()
+	},
 }
 
 func Execute() error {
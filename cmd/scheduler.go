@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/estenssoros/relay"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// dagScheduler the scheduler of a dag binary. Commands that run tasks need its dags
+var dagScheduler *relay.Scheduler
+
+// ExecuteScheduler runs relay from a dag binary. Without a command it runs the scheduler,
+// and tasks run and worker run tasks of the scheduler's dags
+func ExecuteScheduler(s *relay.Scheduler) error {
+	dagScheduler = s
+	rootCmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return s.Run()
+	}
+	return Execute()
+}
+
+// getDagScheduler gets the scheduler of the dag binary
+func getDagScheduler() (*relay.Scheduler, error) {
+	if dagScheduler == nil {
+		return nil, errors.New("tasks only run from a dag binary calling cmd.ExecuteScheduler")
+	}
+	return dagScheduler, nil
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	taskRegex      string
+	taskID         string
+	taskState      string
+	taskNote       string
+	dagRunID       int
+	taskDownstream bool
+	taskUpstream   bool
+)
+
+func init() {
+	tasksCmd.PersistentFlags().StringVarP(&apiURL, "url", "", defaultAPIURL(), "url of the running relay webserver")
+	tasksCmd.AddCommand(tasksClearCmd)
+	tasksCmd.AddCommand(tasksMarkCmd)
+	tasksCmd.AddCommand(tasksKillCmd)
+	tasksCmd.AddCommand(tasksCancelCmd)
+	tasksCmd.AddCommand(tasksRunCmd)
+}
+
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "manage task instances of dag runs",
+}
+
+func init() {
+	tasksClearCmd.Flags().IntVarP(&dagRunID, "run", "r", 0, "dag run id")
+	tasksClearCmd.Flags().StringVarP(&taskRegex, "task", "t", "", "regex of task ids to clear")
+	tasksClearCmd.Flags().BoolVarP(&taskDownstream, "downstream", "d", false, "also clear all downstream tasks")
+	tasksClearCmd.Flags().BoolVarP(&taskUpstream, "upstream", "u", false, "also clear all upstream tasks")
+}
+
+var tasksClearCmd = &cobra.Command{
+	Use:   "clear <dag>",
+	Short: "clear task instances of a dag run and run them again",
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if dagRunID == 0 {
+			return errors.New("must supply run")
+		}
+		if taskRegex == "" {
+			return errors.New("must supply task")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := map[string]interface{}{
+			"task":       taskRegex,
+			"downstream": taskDownstream,
+			"upstream":   taskUpstream,
+		}
+		cleared := []*models.TaskInstance{}
+		path := fmt.Sprintf("/api/dags/%s/runs/%d/clear", url.PathEscape(args[0]), dagRunID)
+		if err := apiRequest(http.MethodPost, path, req, &cleared); err != nil {
+			return errors.Wrap(err, "clear tasks")
+		}
+		for _, t := range cleared {
+			fmt.Println("cleared", t.TaskID)
+		}
+		return nil
+	},
+}
+
+func init() {
+	tasksMarkCmd.Flags().IntVarP(&dagRunID, "run", "r", 0, "dag run id")
+	tasksMarkCmd.Flags().StringVarP(&taskID, "task", "t", "", "task id to mark")
+	tasksMarkCmd.Flags().StringVarP(&taskState, "state", "s", "", "state to set: success, failed or skipped")
+	tasksMarkCmd.Flags().StringVarP(&taskNote, "note", "n", "", "audit note stored on the task instance message")
+	tasksMarkCmd.Flags().BoolVarP(&taskDownstream, "downstream", "d", false, "also mark all downstream tasks")
+	tasksMarkCmd.Flags().BoolVarP(&taskUpstream, "upstream", "u", false, "also mark all upstream tasks")
+}
+
+var tasksMarkCmd = &cobra.Command{
+	Use:   "mark <dag>",
+	Short: "set the state of task instances of a dag run by hand",
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if dagRunID == 0 {
+			return errors.New("must supply run")
+		}
+		if taskID == "" {
+			return errors.New("must supply task")
+		}
+		switch state.State(taskState) {
+		case state.Success, state.Failed, state.Skipped:
+		default:
+			return errors.New("state must be success, failed or skipped")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := map[string]interface{}{
+			"state":      taskState,
+			"note":       taskNote,
+			"downstream": taskDownstream,
+			"upstream":   taskUpstream,
+		}
+		marked := []*models.TaskInstance{}
+		path := fmt.Sprintf("/api/dags/%s/runs/%d/tasks/%s/mark", url.PathEscape(args[0]), dagRunID, url.PathEscape(taskID))
+		if err := apiRequest(http.MethodPost, path, req, &marked); err != nil {
+			return errors.Wrap(err, "mark tasks")
+		}
+		for _, t := range marked {
+			fmt.Println(t.TaskID, t.State, t.Message)
+		}
+		return nil
+	},
+}
+
+func init() {
+	tasksKillCmd.Flags().IntVarP(&dagRunID, "run", "r", 0, "dag run id")
+	tasksKillCmd.Flags().StringVarP(&taskID, "task", "t", "", "task id to kill")
+	tasksCancelCmd.Flags().IntVarP(&dagRunID, "run", "r", 0, "dag run id")
+}
+
+var tasksKillCmd = &cobra.Command{
+	Use:   "kill <dag>",
+	Short: "stop a running task instance of a dag run, failing it",
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if dagRunID == 0 {
+			return errors.New("must supply run")
+		}
+		if taskID == "" {
+			return errors.New("must supply task")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := fmt.Sprintf("/api/dags/%s/runs/%d/tasks/%s/kill", url.PathEscape(args[0]), dagRunID, url.PathEscape(taskID))
+		if err := apiRequest(http.MethodPost, path, nil, nil); err != nil {
+			return errors.Wrap(err, "kill task")
+		}
+		fmt.Printf("killing %s of run %d\n", taskID, dagRunID)
+		return nil
+	},
+}
+
+var tasksCancelCmd = &cobra.Command{
+	Use:   "cancel <dag>",
+	Short: "cancel a dag run, killing its running tasks and skipping the rest",
+	Args:  cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if dagRunID == 0 {
+			return errors.New("must supply run")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := fmt.Sprintf("/api/dags/%s/runs/%d/cancel", url.PathEscape(args[0]), dagRunID)
+		if err := apiRequest(http.MethodPost, path, nil, nil); err != nil {
+			return errors.Wrap(err, "cancel dag run")
+		}
+		fmt.Printf("cancelling run %d\n", dagRunID)
+		return nil
+	},
+}
+
+func init() {
+	tasksRunCmd.Flags().IntVarP(&dagRunID, "run", "r", 0, "dag run id")
+}
+
+var tasksRunCmd = &cobra.Command{
+	Use:   "run <dag> <task>",
+	Short: "run a task instance of a dag run in this process, as the subprocess executor does",
+	Args:  cobra.ExactArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if dagRunID == 0 {
+			return errors.New("must supply run")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := getDagScheduler()
+		if err != nil {
+			return err
+		}
+		return s.RunTask(args[0], args[1], dagRunID)
+	},
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	userRole     string
+	userPassword string
+)
+
+func init() {
+	usersCmd.AddCommand(usersCreateCmd)
+	usersCmd.AddCommand(usersListCmd)
+	usersCmd.AddCommand(usersDeleteCmd)
+}
+
+var usersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "create/list/delete webserver users",
+}
+
+func init() {
+	usersCreateCmd.Flags().StringVarP(&userRole, "role", "r", string(models.RoleViewer), "role of the user: viewer, operator or admin")
+	usersCreateCmd.Flags().StringVarP(&userPassword, "password", "p", "", "password of the user. read from stdin when empty")
+}
+
+var usersCreateCmd = &cobra.Command{
+	Use:   "create <username>",
+	Short: "create a user and print its api token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		password := userPassword
+		if password == "" {
+			fmt.Fprint(os.Stderr, "password: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return errors.Wrap(err, "read password")
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+		user, token, err := models.CreateUser(args[0], password, models.Role(userRole))
+		if err != nil {
+			return errors.Wrap(err, "create user")
+		}
+		fmt.Printf("created %s with role %s\n", user.Username, user.Role)
+		fmt.Printf("api token, shown once: %s\n", token)
+		return nil
+	},
+}
+
+var usersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list users",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		users, err := models.ListUsers()
+		if err != nil {
+			return errors.Wrap(err, "list users")
+		}
+		if len(users) == 0 {
+			fmt.Println("no users present")
+		}
+		for _, user := range users {
+			fmt.Println(user.Username, user.Role, user.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+		}
+		return nil
+	},
+}
+
+var usersDeleteCmd = &cobra.Command{
+	Use:   "delete <username>",
+	Short: "delete a user, ending its sessions and api token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := models.DeleteUser(args[0]); err != nil {
+			return errors.Wrap(err, "delete user")
+		}
+		fmt.Println("deleted", args[0])
+		return nil
+	},
+}
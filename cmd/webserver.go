@@ -1,27 +1,151 @@
 package cmd
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/estenssoros/relay"
+	"github.com/estenssoros/relay/config"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// daemonEnv is set for the background process started by webserver --daemon
+const daemonEnv = "RELAY_WEBSERVER_DAEMON"
+
+var pidFile string
 
 func init() {
 	webserverCmd.Flags().IntVarP(&port, "port", "p", 3000, "the port on which to run the web server")
 	webserverCmd.Flags().IntVarP(&workers, "workers", "w", 4, "number of workers to run the webserver on")
 	webserverCmd.Flags().StringVarP(&workerClass, "worker_class", "k", "sync", "the worker class to use")
-	webserverCmd.Flags().IntVarP(&workerTimeout, "worker_timeout", "t", 120, "the timeout for waiting on webserver workers")
-	webserverCmd.Flags().StringVarP(&hostName, "hostname", "h", "127.0.0.1", "set the hostname on with to run the webserver")
+	webserverCmd.Flags().IntVarP(&workerTimeout, "worker_timeout", "t", 120, "the timeout in seconds for reading a request")
+	webserverCmd.Flags().StringVarP(&hostName, "hostname", "H", "", "set the hostname on with to run the webserver. all interfaces when empty")
 	webserverCmd.Flags().BoolVarP(&daemon, "daemon", "D", false, "deamonize instead of running in the foreground")
-	webserverCmd.Flags().StringVarP(&stdout, "stdout", "", "", "redirect stdout to this file")
-	webserverCmd.Flags().StringVarP(&stderr, "stderr", "", "", "redirect stderr to this file")
+	webserverCmd.Flags().StringVarP(&pidFile, "pid", "", "", "pid file of the daemon. defaults to relay-webserver.pid in the relay folder")
+	webserverCmd.Flags().StringVarP(&stdout, "stdout", "", "", "redirect stdout of the daemon to this file")
+	webserverCmd.Flags().StringVarP(&stderr, "stderr", "", "", "redirect stderr of the daemon to this file")
 	webserverCmd.Flags().StringVarP(&accessLogFile, "access_logfile", "A", "-", "the logfile to store the webserver access log. use '-' to print to stderr")
 	webserverCmd.Flags().StringVarP(&errorLogFile, "error_logfile", "E", "-", "the logfile to store the webserver error log. use '-' to print to stderr.")
 	webserverCmd.Flags().StringVarP(&logFile, "log-file", "l", "", "location of the log file")
 	webserverCmd.Flags().StringVarP(&sslCert, "ssl_cert", "", "", "path to the SSL certificat wfor the webserver")
 	webserverCmd.Flags().StringVarP(&sslKey, "ssl_key", "", "", "path to the key to use with the SSL certificate")
 	webserverCmd.Flags().BoolVarP(&debug, "debug", "d", false, "use the server in debug mode")
+	webserverCmd.Flags().MarkDeprecated("workers", "the webserver is a single process")
+	webserverCmd.Flags().MarkDeprecated("worker_class", "the webserver is a single process")
 }
 
 var webserverCmd = &cobra.Command{
-	Use:     "webserver",
-	Short:   "start a relay webserver instance",
-	PreRunE: func(cmd *cobra.Command, args []string) error { return nil },
-	RunE:    func(cmd *cobra.Command, args []string) error { return nil },
+	Use:   "webserver",
+	Short: "start a relay webserver instance",
+	Long: `start a relay webserver serving the ui and api from the relay database, without a scheduler.
+flags override the webserver config. runs, tasks and the scheduler can only be acted on
+through the webserver of a running scheduler`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		cfg := &config.DefaultConfig.Webserver
+		flags := cmd.Flags()
+		if flags.Changed("port") {
+			cfg.Port = int64(port)
+		}
+		if flags.Changed("hostname") {
+			cfg.Host = hostName
+		}
+		if flags.Changed("worker_timeout") {
+			cfg.WorkerTimeout = workerTimeout
+		}
+		if flags.Changed("access_logfile") {
+			cfg.AccessLogFile = accessLogFile
+		}
+		if flags.Changed("error_logfile") {
+			cfg.ErrorLogFile = errorLogFile
+		}
+		if flags.Changed("ssl_cert") {
+			cfg.SSLCert = sslCert
+		}
+		if flags.Changed("ssl_key") {
+			cfg.SSLKey = sslKey
+		}
+		if debug {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		if logFile != "" {
+			f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return errors.Wrap(err, "open log file")
+			}
+			logrus.SetOutput(f)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if daemon && os.Getenv(daemonEnv) == "" {
+			return daemonize()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			logrus.Info("stopping webserver")
+			cancel()
+		}()
+		return relay.ServeStandalone(ctx)
+	},
+}
+
+// daemonize starts the webserver again in the background, detached from the terminal,
+// and writes its pid file. Its stdout and stderr go to the stdout and stderr flags,
+// defaulting to relay-webserver.out and relay-webserver.err in the relay folder
+func daemonize() error {
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return errors.Wrap(err, "homedir")
+	}
+	folder := filepath.Join(homeDir, "relay")
+	if stdout == "" {
+		stdout = filepath.Join(folder, "relay-webserver.out")
+	}
+	if stderr == "" {
+		stderr = filepath.Join(folder, "relay-webserver.err")
+	}
+	if pidFile == "" {
+		pidFile = filepath.Join(folder, "relay-webserver.pid")
+	}
+	outFile, err := os.OpenFile(stdout, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open stdout")
+	}
+	defer outFile.Close()
+	errFile, err := os.OpenFile(stderr, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open stderr")
+	}
+	defer errFile.Close()
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "executable")
+	}
+	child := exec.Command(executable, os.Args[1:]...)
+	child.Env = append(os.Environ(), daemonEnv+"=1")
+	child.Stdout = outFile
+	child.Stderr = errFile
+	child.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := child.Start(); err != nil {
+		return errors.Wrap(err, "start daemon")
+	}
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(child.Process.Pid)), 0644); err != nil {
+		return errors.Wrap(err, "write pid file")
+	}
+	fmt.Printf("webserver running in the background with pid %d\n", child.Process.Pid)
+	return child.Process.Release()
 }
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var (
+	workerConcurrency int
+	workerName        string
+)
+
+func init() {
+	workerCmd.Flags().IntVarP(&workerConcurrency, "concurrency", "c", 0, "number of tasks run at once. defaults to parallelism")
+	workerCmd.Flags().StringVarP(&workerName, "name", "n", "", "worker name. defaults to a random name")
+}
+
+var workerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "run tasks enqueued in the relay database by a scheduler with the distributed executor",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := getDagScheduler()
+		if err != nil {
+			return err
+		}
+		return s.RunWorker(workerConcurrency, workerName)
+	},
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
@@ -21,6 +24,7 @@ type Core struct {
 	MaxActiveRunsPerDag     int    `yaml:"max_active_runs_per_dag" json:"max_active_runs_per_dag"`
 	CipherKey               string `yaml:"cipher_key" json:"cipher_key"`
 	TaskRunner              string `yaml:"task_runner" json:"task_runner"`
+	BaseLogFolder           string `yaml:"base_log_folder" json:"base_log_folder"`
 }
 
 // DBCreds creds for database
@@ -39,13 +43,38 @@ type Webserver struct {
 	Workers        int    `yaml:"workers" json:"workers"`
 	WorkerClass    string `yaml:"worker_class" json:"worker_class"`
 	DagOrientation string `yaml:"dag_orientation" json:"dag_orientation"`
+	SecretKey      string `yaml:"secret_key" json:"secret_key"`
+	Host           string `yaml:"host" json:"host"`
+	SSLCert        string `yaml:"ssl_cert" json:"ssl_cert"`
+	SSLKey         string `yaml:"ssl_key" json:"ssl_key"`
+	AccessLogFile  string `yaml:"access_logfile" json:"access_logfile"`
+	ErrorLogFile   string `yaml:"error_logfile" json:"error_logfile"`
+	WorkerTimeout  int    `yaml:"worker_timeout" json:"worker_timeout"`
+	Authenticate   bool   `yaml:"authenticate" json:"authenticate"`
 }
 
 // Scheduler scheduler config
 type Scheduler struct {
-	JobHeartBeatSec       int `yaml:"job_heart_beat_sec" json:"job_heart_beat_sec"`
-	SchedulerHeartBeatSec int `yaml:"scheduler_heartbeat_sec" json:"scheduler_heartbeat_sec"`
-	NumRuns               int `yaml:"num_runs" json:"num_runs"`
+	JobHeartBeatSec       int    `yaml:"job_heart_beat_sec" json:"job_heart_beat_sec"`
+	SchedulerHeartBeatSec int    `yaml:"scheduler_heartbeat_sec" json:"scheduler_heartbeat_sec"`
+	NumRuns               int    `yaml:"num_runs" json:"num_runs"`
+	ZombiePolicy          string `yaml:"zombie_policy" json:"zombie_policy"`
+	GracefulShutdownSec   int    `yaml:"graceful_shutdown_sec" json:"graceful_shutdown_sec"`
+	LeaderLeaseSec        int    `yaml:"leader_lease_sec" json:"leader_lease_sec"`
+}
+
+// Metrics statsd config. Metrics are always served at /metrics
+type Metrics struct {
+	StatsdOn     bool   `yaml:"statsd_on" json:"statsd_on"`
+	StatsdHost   string `yaml:"statsd_host" json:"statsd_host"`
+	StatsdPort   int    `yaml:"statsd_port" json:"statsd_port"`
+	StatsdPrefix string `yaml:"statsd_prefix" json:"statsd_prefix"`
+}
+
+// Traces opentelemetry config. Spans are exported when an otlp endpoint is set
+type Traces struct {
+	OTLPEndpoint string `yaml:"otlp_endpoint" json:"otlp_endpoint"`
+	ServiceName  string `yaml:"service_name" json:"service_name"`
 }
 
 // Config holds all configs
@@ -54,6 +83,8 @@ type Config struct {
 	DBCreds   `yaml:"db_creds" json:"db_creds"`
 	Webserver `yaml:"webserver" json:"webserver"`
 	Scheduler `yaml:"scheduler" json:"scheduler"`
+	Metrics   `yaml:"metrics" json:"metrics"`
+	Traces    `yaml:"traces" json:"traces"`
 	Error     error
 }
 
@@ -84,8 +115,16 @@ func Load() (*Config, error) {
 		return nil, errors.Wrap(err, "readfile")
 	}
 	defer f.Close()
-	config := &Config{}
-	if err := yaml.NewDecoder(f).Decode(config); err != nil {
+	return decode(f)
+}
+
+// decode decodes a config. Authentication is on unless the config turns it off, also in
+// configs written before it could be set
+func decode(r io.Reader) (*Config, error) {
+	config := &Config{
+		Webserver: Webserver{Authenticate: true},
+	}
+	if err := yaml.NewDecoder(r).Decode(config); err != nil {
 		return nil, errors.Wrap(err, "decode")
 	}
 	return config, nil
@@ -99,6 +138,38 @@ func CipherKeyBytes() ([]byte, error) {
 	return []byte(DefaultConfig.Core.CipherKey), nil
 }
 
+// LogFolder folder task logs are written to. Defaults to logs in the relay folder
+func LogFolder() (string, error) {
+	if DefaultConfig != nil && DefaultConfig.Core.BaseLogFolder != "" {
+		return DefaultConfig.Core.BaseLogFolder, nil
+	}
+	homeDir, err := homedir.Dir()
+	if err != nil {
+		return "", errors.Wrap(err, "homedir")
+	}
+	return filepath.Join(homeDir, "relay", "logs"), nil
+}
+
+// StatsdAddr address of the statsd server. Defaults to localhost:8125
+func StatsdAddr() string {
+	host, port := DefaultConfig.Metrics.StatsdHost, DefaultConfig.Metrics.StatsdPort
+	if host == "" {
+		host = defaultStatsdHost
+	}
+	if port == 0 {
+		port = defaultStatsdPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// ServiceName service name of exported spans. Defaults to relay
+func ServiceName() string {
+	if name := DefaultConfig.Traces.ServiceName; name != "" {
+		return name
+	}
+	return defaultServiceName
+}
+
 func newCipherKey() string {
 	return ``
 }
@@ -129,11 +200,25 @@ func createConfig(path string) error {
 			Workers:        defaultWorkers,
 			WorkerClass:    defaultWorkerClass,
 			DagOrientation: defaultDagOrientation,
+			WorkerTimeout:  defaultWorkerTimeout,
+			Authenticate:   true,
 		},
 		Scheduler: Scheduler{
 			JobHeartBeatSec:       defaultJobHeartBeatSec,
 			SchedulerHeartBeatSec: defaultSchedulerheartBeatSec,
 			NumRuns:               defaultNumRuns,
+			ZombiePolicy:          defaultZombiePolicy,
+			GracefulShutdownSec:   defaultGracefulShutdownSec,
+			LeaderLeaseSec:        defaultLeaderLeaseSec,
+		},
+		Metrics: Metrics{
+			StatsdOn:     false,
+			StatsdHost:   defaultStatsdHost,
+			StatsdPort:   defaultStatsdPort,
+			StatsdPrefix: defaultStatsdPrefix,
+		},
+		Traces: Traces{
+			ServiceName: defaultServiceName,
 		},
 		Error: nil,
 	}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -14,8 +16,28 @@ func TestGet(t *testing.T) {
 	fmt.Println(config)
 }
 
+func TestDecodeAuthenticate(t *testing.T) {
+	config, err := decode(strings.NewReader("webserver:\n  port: 3000\n"))
+	assert.Nil(t, err)
+	assert.True(t, config.Authenticate, "on when the key is missing")
+	config, err = decode(strings.NewReader("webserver:\n  authenticate: false\n"))
+	assert.Nil(t, err)
+	assert.False(t, config.Authenticate)
+}
+
 func TestCipherKeyBytes(t *testing.T) {
 	b, err := CipherKeyBytes()
 	assert.Nil(t, err)
 	assert.Equal(t, []byte(DefaultConfig.CipherKey), b)
 }
+
+func TestLocation(t *testing.T) {
+	loc, err := Location("utc")
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, loc)
+	loc, err = Location("America/New_York")
+	assert.Nil(t, err)
+	assert.Equal(t, "America/New_York", loc.String())
+	_, err = Location("Mars/Olympus_Mons")
+	assert.NotNil(t, err)
+}
@@ -3,17 +3,25 @@ package config
 var (
 	defaultHome                  string
 	defaultTimeZone              = "utc"
-	defaultExecutor              = "SequentialExecutor"
+	defaultExecutor              = "LocalExecutor"
 	defaultSQLConn               string
 	defaultParrallelism          = 32
 	defaultDagConcurrency        = 16
 	defaultMaxActiveRunsPerDag   = 16
-	defaultTaskRunner            = "StandardTaksRunner"
+	defaultTaskRunner            = "StandardTaskRunner"
 	defaultPort                  = 3000
 	defaultWorkers               = 4
 	defaultWorkerClass           = "sync"
 	defaultDagOrientation        = "LR"
+	defaultWorkerTimeout         = 120
 	defaultJobHeartBeatSec       = 5
 	defaultSchedulerheartBeatSec = 5
 	defaultNumRuns               = -1
+	defaultZombiePolicy          = "retry"
+	defaultGracefulShutdownSec   = 60
+	defaultLeaderLeaseSec        = 30
+	defaultStatsdHost            = "localhost"
+	defaultStatsdPort            = 8125
+	defaultStatsdPrefix          = "relay"
+	defaultServiceName           = "relay"
 )
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// Location loads a time zone by name, defaulting to the core default time zone. utc and
+// local are accepted in any case
+func Location(name string) (*time.Location, error) {
+	if name == "" && DefaultConfig != nil {
+		name = DefaultConfig.Core.DefaultTimeZone
+	}
+	switch strings.ToLower(name) {
+	case "", "utc":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
@@ -4,15 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/estenssoros/dasorm/nulls"
+	"github.com/estenssoros/relay/config"
 	"github.com/estenssoros/relay/db"
 	"github.com/estenssoros/relay/models"
 	"github.com/estenssoros/relay/state"
-	"github.com/gorhill/cronexpr"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -22,6 +24,8 @@ type DAG struct {
 	ID                   string
 	Description          string
 	ScheduleInterval     string
+	Timetable            Timetable `json:"-"`
+	Timezone             string
 	StartDate            time.Time
 	EndDate              time.Time
 	DefaultArgs          []interface{}
@@ -37,7 +41,9 @@ type DAG struct {
 	AccessControl        map[string]string
 	IsPausedUponCreation bool
 
-	tasks map[string]TaskInterface
+	tasks    map[string]TaskInterface
+	location *time.Location
+	hash     string
 }
 
 // FormattedID formatted dag id
@@ -65,8 +71,8 @@ func printSeparator(sep string, num int) {
 	fmt.Println(strings.Repeat(sep, num))
 }
 
-// Run runs a dag
-func (d *DAG) Run(ctx context.Context, dagRun *models.DagRun) (err error) {
+// Run runs a dag run with a task runner
+func (d *DAG) Run(ctx context.Context, runner *TaskRunner, dagRun *models.DagRun) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
@@ -74,68 +80,318 @@ func (d *DAG) Run(ctx context.Context, dagRun *models.DagRun) (err error) {
 	}()
 	start := time.Now()
 
-	runner := NewTaskRunner(d.tasks)
 	if err := runner.Check(); err != nil {
 		return errors.Wrap(err, "runner check")
 	}
-	var w sync.WaitGroup
-	w.Add(1)
-
-	go runner.Run(ctx, &w)
-
 	dagRun.UpdateState(state.Running)
+	publishDagRun(dagRun)
+	span := startDagRunSpan(dagRun)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+		if err != nil {
+			finishSpan(span, state.Failed, err.Error())
+			return
+		}
+		finishSpan(span, dagRun.State, "")
+	}()
+
+	taskModels, err := d.taskModels(dagRun)
+	if err != nil {
+		return errors.Wrap(err, "task models")
+	}
 
-	for _, task := range d.tasks {
-		if task.IsRoot() {
-			task.SetState(state.Queued)
+	for taskID, ti := range runner.Tasks {
+		taskModel, ok := taskModels[taskID]
+		if ok && isFinished(taskModel.State) { // kept from an earlier attempt at this dag run
+			ti.State = taskModel.State
+			ti.Model = taskModel
+			continue
 		}
-		taskModel := &models.TaskInstance{
-			TaskID:    task.GetID(),
-			DagRunID:  dagRun.ID,
-			StartDate: time.Now().UTC(),
-			EndDate:   nulls.Time{},
-			State:     task.GetState(),
-			Operator:  task.OperatorType(),
+		if !ok {
+			taskModel = &models.TaskInstance{
+				TaskID:         taskID,
+				DagRunID:       dagRun.ID,
+				StartDate:      time.Now().UTC(),
+				EndDate:        nulls.Time{},
+				Operator:       ti.Task.OperatorType(),
+				Pool:           ti.Task.GetPool(),
+				PriorityWeight: priorityWeight(ti.Task),
+				MaxTries:       ti.Task.GetRetries() + 1,
+			}
 		}
-		if err := taskModel.Create(); err != nil {
-			return errors.Wrap(err, "create task model")
+		ti.State = state.Pending
+		taskModel.State = state.Pending
+		if err := taskModel.Update(); err != nil {
+			return errors.Wrap(err, "save task model")
 		}
-		task.SetModel(taskModel)
-		runner.evalQueue <- task
+		ti.Model = taskModel
+		ti.traceParent = span.SpanContext()
 	}
 
+	var w sync.WaitGroup
+	w.Add(1)
+
+	go runner.Run(ctx, &w)
+
 	w.Wait()
 
+	if ctx.Err() != nil {
+		logrus.Infof("%s run %d interrupted, it resumes when the scheduler starts", d.FormattedID(), dagRun.ID)
+		return nil
+	}
+
 	dagRun.Finish(runner.FinalState())
+	publishDagRun(dagRun)
+	recordDagRun(dagRun)
 
 	logrus.Infof("dag took %v", time.Since(start))
 	return nil
 }
 
+// isFinished checks if a task state is final and does not need to be run again
+func isFinished(s state.State) bool {
+	switch s {
+	case state.Success, state.Failed, state.Skipped:
+		return true
+	}
+	return false
+}
+
+// taskModels gets the task instances already stored for a dag run by task id
+func (d *DAG) taskModels(dagRun *models.DagRun) (map[string]*models.TaskInstance, error) {
+	taskInstances, err := dagRun.TaskInstances()
+	if err != nil {
+		return nil, err
+	}
+	taskModels := map[string]*models.TaskInstance{}
+	for _, t := range taskInstances {
+		taskModels[t.TaskID] = t
+	}
+	return taskModels, nil
+}
+
+// Clear resets the task instances of a dag run whose task id matches taskRegex, optionally
+// along with all of their downstream and upstream tasks, so they run again when the dag
+// run is rerun. The dag run is set back to queued
+func (d *DAG) Clear(dagRun *models.DagRun, taskRegex string, downstream, upstream bool) ([]*models.TaskInstance, error) {
+	re, err := regexp.Compile(taskRegex)
+	if err != nil {
+		return nil, errors.Wrap(err, "task regex")
+	}
+	selected := map[string]TaskInterface{}
+	for taskID, task := range d.tasks {
+		if !re.MatchString(taskID) {
+			continue
+		}
+		selected[taskID] = task
+		if downstream {
+			relatives(task, TaskInterface.downstreamList, selected)
+		}
+		if upstream {
+			relatives(task, TaskInterface.upstreamList, selected)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.Errorf("no tasks in %s match %s", d.FormattedID(), taskRegex)
+	}
+	taskModels, err := d.taskModels(dagRun)
+	if err != nil {
+		return nil, errors.Wrap(err, "task models")
+	}
+	cleared := []*models.TaskInstance{}
+	for taskID := range selected {
+		taskModel, ok := taskModels[taskID]
+		if !ok {
+			continue
+		}
+		if err := taskModel.Clear(); err != nil {
+			return nil, errors.Wrapf(err, "clear %s", taskID)
+		}
+		cleared = append(cleared, taskModel)
+	}
+	if err := dagRun.UpdateState(state.Queued); err != nil {
+		return nil, errors.Wrap(err, "update dag run state")
+	}
+	publishDagRun(dagRun)
+	return cleared, nil
+}
+
+// selectTasks gets the task ids of a task along with all of its downstream and upstream tasks
+func (d *DAG) selectTasks(taskID string, downstream, upstream bool) ([]string, error) {
+	task, err := d.getTask(taskID)
+	if err != nil {
+		return nil, err
+	}
+	selected := map[string]TaskInterface{taskID: task}
+	if downstream {
+		relatives(task, TaskInterface.downstreamList, selected)
+	}
+	if upstream {
+		relatives(task, TaskInterface.upstreamList, selected)
+	}
+	taskIDs := []string{}
+	for id := range selected {
+		taskIDs = append(taskIDs, id)
+	}
+	sort.Strings(taskIDs)
+	return taskIDs, nil
+}
+
+// isMarkable checks if a task state can be set manually
+func isMarkable(s state.State) bool {
+	switch s {
+	case state.Success, state.Failed, state.Skipped:
+		return true
+	}
+	return false
+}
+
+// Mark sets the stored task instances of a dag run to a state with an audit note
+func (d *DAG) Mark(dagRun *models.DagRun, taskIDs []string, s state.State, note string) ([]*models.TaskInstance, error) {
+	if !isMarkable(s) {
+		return nil, errors.Errorf("cannot mark tasks %s", s)
+	}
+	taskModels, err := d.taskModels(dagRun)
+	if err != nil {
+		return nil, errors.Wrap(err, "task models")
+	}
+	marked := []*models.TaskInstance{}
+	for _, taskID := range taskIDs {
+		taskModel, ok := taskModels[taskID]
+		if !ok {
+			task, err := d.getTask(taskID)
+			if err != nil {
+				return nil, err
+			}
+			taskModel = &models.TaskInstance{
+				TaskID:    taskID,
+				DagRunID:  dagRun.ID,
+				StartDate: time.Now().UTC(),
+				Operator:  task.OperatorType(),
+			}
+		}
+		if err := taskModel.Mark(s, note); err != nil {
+			return nil, errors.Wrapf(err, "mark %s", taskID)
+		}
+		marked = append(marked, taskModel)
+	}
+	return marked, nil
+}
+
 // DagConfig basic config for a new dag
 type DagConfig struct {
 	ID               string
 	Description      string
 	ScheduleInterval string
+	Timetable        Timetable
+	Timezone         string
+	StartDate        time.Time
+	EndDate          time.Time
+	Catchup          bool
+	Concurrency      int
+	MaxActiveRuns    int
+	// AccessControl maps the users allowed to see the dag to their role on it. Dags without
+	// access control can be seen by every user
+	AccessControl map[string]string
 }
 
 // NewDag creats a new dag
 func NewDag(input *DagConfig) (*DAG, error) {
+	location, err := config.Location(input.Timezone)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dag %s timezone", input.ID)
+	}
+	for username, role := range input.AccessControl {
+		if !models.Role(role).Valid() {
+			return nil, errors.Errorf("dag %s access control: unknown role %q for %s", input.ID, role, username)
+		}
+	}
 	return &DAG{
 		ID:               input.ID,
 		Description:      input.Description,
 		ScheduleInterval: input.ScheduleInterval,
+		Timetable:        input.Timetable,
+		Timezone:         location.String(),
+		StartDate:        input.StartDate.UTC(),
+		EndDate:          input.EndDate.UTC(),
+		Catchup:          input.Catchup,
+		Concurrency:      input.Concurrency,
+		MaxActiveRuns:    input.MaxActiveRuns,
+		AccessControl:    input.AccessControl,
 		tasks:            map[string]TaskInterface{},
+		location:         location,
 	}, nil
 }
 
-// AddTask sets the task state to pending and adds a task to a dag
+// dagFromModel creates a dag from its row in the relay database and its latest version.
+// Dags stored without a version have the schedule and time zone of the row and no tasks
+func dagFromModel(dagModel *models.DAG) (*DAG, error) {
+	if dagModel.Hash != "" {
+		version, err := models.GetDagVersion(dagModel.ID, dagModel.Hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "dag %s version %s", dagModel.ID, dagModel.Hash)
+		}
+		dag, err := deserializeDag(version.Structure)
+		if err != nil {
+			return nil, errors.Wrapf(err, "dag %s version %s", dagModel.ID, dagModel.Hash)
+		}
+		dag.hash = dagModel.Hash
+		return dag, nil
+	}
+	return dagFromRow(dagModel)
+}
+
+// dagFromRow creates a dag with the schedule and time zone of its row in the relay
+// database and no tasks
+func dagFromRow(dagModel *models.DAG) (*DAG, error) {
+	return NewDag(&DagConfig{
+		ID:               dagModel.ID,
+		Description:      dagModel.Description,
+		ScheduleInterval: dagModel.ScheduleInterval,
+		Timezone:         dagModel.Timezone,
+	})
+}
+
+// Location the time zone the schedule of the dag is evaluated and its dates displayed in.
+// Defaults to config default time zone
+func (d *DAG) Location() *time.Location {
+	if d.location != nil {
+		return d.location
+	}
+	location, err := config.Location(d.Timezone)
+	if err != nil {
+		logrus.Warnf("%s timezone: %v, using UTC", d.FormattedID(), err)
+		return time.UTC
+	}
+	return location
+}
+
+// concurrency max number of task instances of the dag allowed to run at once across
+// all of its runs. Defaults to config dag concurrency, zero or less is unlimited
+func (d *DAG) concurrency() int {
+	if d.Concurrency != 0 {
+		return d.Concurrency
+	}
+	return config.DefaultConfig.Core.DagConcurrency
+}
+
+// maxActiveRuns max number of runs of the dag allowed at once. Defaults to config
+// max active runs per dag, zero or less is unlimited
+func (d *DAG) maxActiveRuns() int {
+	if d.MaxActiveRuns != 0 {
+		return d.MaxActiveRuns
+	}
+	return config.DefaultConfig.Core.MaxActiveRunsPerDag
+}
+
+// AddTask adds a task to a dag
 func (d *DAG) AddTask(t TaskInterface) error {
 	_, ok := d.tasks[t.GetID()]
 	if ok {
 		return errors.Errorf("task %s already exists in dag", t.GetID())
 	}
-	t.SetState(state.Pending)
 	d.tasks[t.GetID()] = t
 	t.SetDag(d)
 	return nil
@@ -159,6 +415,42 @@ func (d *DAG) getTask(taskID string) (TaskInterface, error) {
 	return t, nil
 }
 
+// SetDependency makes the downstream task depend on the upstream task
+func (d *DAG) SetDependency(upstreamID, downstreamID string) error {
+	upstream, err := d.getTask(upstreamID)
+	if err != nil {
+		return errors.Wrap(err, "upstream")
+	}
+	downstream, err := d.getTask(downstreamID)
+	if err != nil {
+		return errors.Wrap(err, "downstream")
+	}
+	return addDependency(upstream, downstream)
+}
+
+// Chain sets each task downstream of the task before it. dag.Chain(t1, t2, t3)
+// is the same as t1 >> t2 >> t3
+func (d *DAG) Chain(tasks ...TaskInterface) error {
+	for i := 1; i < len(tasks); i++ {
+		if err := d.SetDependency(tasks[i-1].GetID(), tasks[i].GetID()); err != nil {
+			return errors.Wrapf(err, "chain position %d", i)
+		}
+	}
+	return nil
+}
+
+// CrossDownstream sets every task in to downstream of every task in from
+func (d *DAG) CrossDownstream(from, to []TaskInterface) error {
+	for _, upstream := range from {
+		for _, downstream := range to {
+			if err := d.SetDependency(upstream.GetID(), downstream.GetID()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func getUpstream(task TaskInterface, level int) error {
 	fmt.Println(strings.Repeat("\t", level) + task.String())
 	level++
@@ -183,25 +475,27 @@ func (d *DAG) TreeView() error {
 	return nil
 }
 
-// NextRun returns the next run time based on the chron expression
-func (d *DAG) NextRun() (time.Time, error) {
-	cron, err := cronexpr.Parse(d.ScheduleInterval)
-	if err != nil {
-		return time.Time{}, errors.Wrap(err, "cron parse")
-	}
-	return cron.Next(time.Now().UTC()), nil
-}
-
 func (d *DAG) getOrCreateDagModel() error {
 	conn := db.Connection
 	dagModel := &models.DAG{}
-	conn.Where(models.DAG{ID: d.ID}).Attrs(models.DAG{
+	conn.Where(models.DAG{ID: d.ID}).Assign(models.DAG{
 		Description:      d.Description,
 		ScheduleInterval: d.ScheduleInterval,
+		Timezone:         d.Location().String(),
+		Hash:             d.hash,
 	}).FirstOrCreate(&dagModel)
 	return conn.Error
 }
 
+// isPaused checks if the dag is paused in the relay database
+func (d *DAG) isPaused() bool {
+	dagModel, err := models.GetDag(d.ID)
+	if err != nil {
+		return false
+	}
+	return dagModel.IsPaused
+}
+
 func (d *DAG) updateNextScheduled() error {
 	conn := db.Connection
 	dagModel := &models.DAG{}
@@ -213,10 +507,16 @@ func (d *DAG) updateNextScheduled() error {
 
 // DagRun creeates a dag run model from a dag
 func (d *DAG) DagRun() *models.DagRun {
+	return d.DagRunAt(time.Now().UTC())
+}
+
+// DagRunAt creates a dag run model from a dag for an execution date
+func (d *DAG) DagRunAt(executionDate time.Time) *models.DagRun {
 	return &models.DagRun{
 		DagID:         d.ID,
-		ExecutionDate: time.Now().UTC(),
-		State:         state.Pending,
+		ExecutionDate: executionDate,
+		State:         state.Queued,
 		StartDate:     time.Now().UTC(),
+		DagHash:       d.hash,
 	}
 }
@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DagBag the dags of a scheduler by id. Dags can be added and removed while the scheduler,
+// webserver and workers read them
+type DagBag struct {
+	mu   sync.RWMutex
+	dags map[string]*DAG
+}
+
+// NewDagBag creates a dag bag holding dags
+func NewDagBag(dags ...*DAG) *DagBag {
+	b := &DagBag{dags: map[string]*DAG{}}
+	for _, dag := range dags {
+		b.add(dag)
+	}
+	return b
+}
+
+// LoadDagBag loads the dags stored in the relay database. The dags describe themselves
+// for the webserver and have no tasks to run
+func LoadDagBag() (*DagBag, error) {
+	b := NewDagBag()
+	return b, b.reload()
+}
+
+// reload replaces the dags of the bag with the dags stored in the relay database
+func (b *DagBag) reload() error {
+	dagModels, err := models.ListDags()
+	if err != nil {
+		return errors.Wrap(err, "list dags")
+	}
+	dags := map[string]*DAG{}
+	for _, dagModel := range dagModels {
+		dag, err := dagFromModel(dagModel)
+		dagLoads.Inc(result(err))
+		if err != nil {
+			// the dag is still listed, with its runs and task instances but no tasks
+			logrus.Warn(errors.Wrap(err, "load dag, leaving out its tasks"))
+			if dag, err = dagFromRow(dagModel); err != nil {
+				logrus.Warn(errors.Wrap(err, "load dag"))
+				continue
+			}
+		}
+		dags[dag.ID] = dag
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dags = dags
+	return nil
+}
+
+// Get gets a dag by id
+func (b *DagBag) Get(dagID string) (*DAG, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	dag, ok := b.dags[dagID]
+	return dag, ok
+}
+
+// List lists the dags sorted by id
+func (b *DagBag) List() []*DAG {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	dags := make([]*DAG, 0, len(b.dags))
+	for _, dag := range b.dags {
+		dags = append(dags, dag)
+	}
+	sort.Slice(dags, func(i, j int) bool { return dags[i].ID < dags[j].ID })
+	return dags
+}
+
+// add adds a dag. Returns false if a dag with the same id is already in the bag
+func (b *DagBag) add(dag *DAG) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.dags[dag.ID]; ok {
+		return false
+	}
+	b.dags[dag.ID] = dag
+	return true
+}
+
+// remove removes a dag by id. Returns false if it was not in the bag
+func (b *DagBag) remove(dagID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.dags[dagID]; !ok {
+		return false
+	}
+	delete(b.dags, dagID)
+	return true
+}
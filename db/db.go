@@ -1,23 +1,34 @@
 package db
 
 import (
+	"time"
+
 	"github.com/estenssoros/relay/config"
 	"github.com/jinzhu/gorm"
 	"github.com/pkg/errors"
-
-	"github.com/sirupsen/logrus"
 )
 
-var Connection *gorm.DB
+var (
+	// Connection the connection to the relay database, nil when connecting failed
+	Connection *gorm.DB
+	connectErr error
+)
 
 func init() {
 	conn, err := Connect()
 	if err != nil {
-		logrus.Fatal(errors.Wrap(err, "connect"))
+		connectErr = errors.Wrap(err, "connect to relay database")
+		return
 	}
 	Connection = conn
 }
 
+// Err the error connecting to the relay database at start, nil when connected. Commands
+// fail with it before using the connection, except health which reports it
+func Err() error {
+	return connectErr
+}
+
 func Connect() (*gorm.DB, error) {
 	switch config.DefaultConfig.DBCreds.Flavor {
 	case "sqlite":
@@ -30,3 +41,36 @@ func Connect() (*gorm.DB, error) {
 		return nil, errors.Errorf("not supported: %s", config.DefaultConfig.DBCreds.Flavor)
 	}
 }
+
+// Ping checks the relay database can be reached
+func Ping() error {
+	if Connection == nil {
+		return connectErr
+	}
+	return Connection.DB().Ping()
+}
+
+// nowQueries select the current time of the database in UTC by dialect
+var nowQueries = map[string]string{
+	"sqlite3":  "SELECT strftime('%Y-%m-%d %H:%M:%f', 'now')",
+	"mysql":    "SELECT DATE_FORMAT(UTC_TIMESTAMP(6), '%Y-%m-%d %H:%i:%s.%f')",
+	"postgres": "SELECT to_char(now() at time zone 'utc', 'YYYY-MM-DD HH24:MI:SS.US')",
+}
+
+// Now the current time of the relay database in UTC, so processes on different hosts
+// compare times on one clock
+func Now() (time.Time, error) {
+	if Connection == nil {
+		return time.Time{}, connectErr
+	}
+	query, ok := nowQueries[Connection.Dialect().GetName()]
+	if !ok {
+		return time.Time{}, errors.Errorf("no clock for %s", Connection.Dialect().GetName())
+	}
+	var now string
+	if err := Connection.Raw(query).Row().Scan(&now); err != nil {
+		return time.Time{}, errors.Wrap(err, "database clock")
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", now)
+	return t, errors.Wrap(err, "database clock")
+}
@@ -0,0 +1,252 @@
+package relay
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// queuePollInterval how often the relay database queue is checked for claimed tasks and results
+var queuePollInterval = time.Second
+
+// distributedExecutor only enqueues tasks in the relay database for queue workers to run.
+// It holds a slot until the result is written back so pools, dag concurrency and
+// parallelism still apply across every worker
+type distributedExecutor struct {
+	parallelism int
+}
+
+func (e *distributedExecutor) Slots() int { return e.parallelism }
+
+func (e *distributedExecutor) Execute(ctx context.Context, ti *TaskInstance) error {
+	q, err := models.EnqueueTask(ti.Model, ti.Task.GetDag().ID, traceparent(ctx))
+	if err != nil {
+		return errors.Wrap(err, "enqueue task")
+	}
+	defer q.Delete()
+	logrus.Infof("%s enqueued for workers", ti.FormattedID())
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	claimedBy := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "interrupted")
+		}
+		if err := q.Reload(); err != nil {
+			return errors.Wrap(err, "reload queued task")
+		}
+		switch q.State {
+		case state.Success:
+			return nil
+		case state.Failed:
+			return errors.New(q.Message)
+		case state.Running:
+			if q.JobID != 0 && q.JobID != claimedBy {
+				claimedBy = q.JobID
+				stampJob(ti, q.JobID)
+			}
+			if time.Since(q.HeartbeatAt) <= heartbeatTimeout() {
+				continue
+			}
+			if zombieFails(ti.Model, zombiePolicy()) {
+				return errors.Errorf("zombie: worker %s stopped heartbeating", q.Worker)
+			}
+			logrus.Warnf("%s zombie: worker %s stopped heartbeating, releasing it for another worker", ti.FormattedID(), q.Worker)
+			ti.Model.TryNumber++
+			if err := ti.Model.Update(); err != nil {
+				return errors.Wrap(err, "retry zombie")
+			}
+			if err := q.Release(); err != nil {
+				return errors.Wrap(err, "release zombie")
+			}
+		}
+	}
+}
+
+// stampJob stamps a task instance with the job of the worker that claimed it
+func stampJob(ti *TaskInstance, jobID int) {
+	job, err := models.GetJob(jobID)
+	if err != nil {
+		logrus.Error(errors.Wrapf(err, "get job %d", jobID))
+		return
+	}
+	ti.Model.SetJob(job)
+	if err := ti.Model.Update(); err != nil {
+		logrus.Error(errors.Wrap(err, "stamp job"))
+	}
+}
+
+// QueueWorker runs tasks claimed from the relay database queue. Queue workers run in the
+// dag binary, on any number of machines, so they know the tasks of every dag
+type QueueWorker struct {
+	Name        string
+	Dags        *DagBag
+	Concurrency int
+	Job         *models.Job
+	running     sync.WaitGroup
+	taskCtx     context.Context
+	stopTasks   context.CancelFunc
+}
+
+// NewQueueWorker creates a queue worker that runs up to parallelism tasks at once
+func NewQueueWorker(dags *DagBag) *QueueWorker {
+	taskCtx, stopTasks := context.WithCancel(context.Background())
+	return &QueueWorker{
+		Name:        namer.randomName(),
+		Dags:        dags,
+		Concurrency: config.DefaultConfig.Core.Parallelism,
+		taskCtx:     taskCtx,
+		stopTasks:   stopTasks,
+	}
+}
+
+// Start claims and runs tasks until ctx is cancelled
+func (w *QueueWorker) Start(ctx context.Context) {
+	w.running.Add(1)
+	defer w.running.Done()
+	logrus.Infof("queue worker %s running %d tasks at once", w.Name, w.Concurrency)
+	slots := make(chan struct{}, w.Concurrency)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		jobID := 0
+		if w.Job != nil {
+			jobID = w.Job.ID
+		}
+		q, err := models.ClaimQueuedTask(w.Name, jobID)
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "claim queued task"))
+		}
+		if q == nil {
+			<-slots
+			select {
+			case <-time.After(queuePollInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		w.running.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				w.running.Done()
+			}()
+			w.run(q)
+		}()
+	}
+}
+
+// Wait waits for running tasks to finish
+func (w *QueueWorker) Wait() {
+	w.running.Wait()
+}
+
+// StopTasks stops running tasks. Their queued tasks are released for another worker
+func (w *QueueWorker) StopTasks() {
+	w.stopTasks()
+}
+
+// run runs a claimed task, heartbeating until it finishes, and writes the result back
+func (w *QueueWorker) run(q *models.QueuedTask) {
+	dag, ok := w.Dags.Get(q.DagID)
+	if !ok {
+		q.Finish(state.Failed, "worker does not have dag "+q.DagID)
+		return
+	}
+	task, err := dag.getTask(q.TaskID)
+	if err != nil {
+		q.Finish(state.Failed, err.Error())
+		return
+	}
+	logrus.Infof("%s running %s (run %d)", w.Name, task.FormattedID(), q.DagRunID)
+
+	ctx, cancel := context.WithCancel(contextWithTraceparent(w.taskCtx, q.Traceparent))
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runLogged(ctx, task, q.DagRunID, currentTry(q.DagRunID, q.TaskID))
+	}()
+	ticker := time.NewTicker(heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			switch {
+			case w.taskCtx.Err() != nil:
+				logrus.Warnf("%s interrupted, releasing it", task.FormattedID())
+				err = q.Release()
+			case err != nil:
+				logrus.Errorf("%s failed", task.FormattedID())
+				err = q.Finish(state.Failed, err.Error())
+			default:
+				logrus.Infof("%s success", task.FormattedID())
+				err = q.Finish(state.Success, "")
+			}
+			if err != nil {
+				logrus.Error(errors.Wrap(err, "write result"))
+			}
+			return
+		case <-ticker.C:
+			queued, err := q.Heartbeat()
+			if err != nil {
+				logrus.Error(errors.Wrap(err, "heartbeat"))
+			} else if !queued {
+				logrus.Warnf("%s taken off the queue, stopping it", task.FormattedID())
+				cancel()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+// RunWorker runs a queue worker for the scheduler's dags until SIGINT or SIGTERM, then
+// drains it like the scheduler. Zero concurrency and an empty name keep the defaults of
+// NewQueueWorker
+func (s *Scheduler) RunWorker(concurrency int, name string) error {
+	w := NewQueueWorker(s.Dags)
+	if concurrency != 0 {
+		w.Concurrency = concurrency
+	}
+	if name != "" {
+		w.Name = name
+	}
+	if w.Concurrency <= 0 {
+		return errors.New("concurrency must be positive")
+	}
+
+	job, stopJob, err := startJob(JobWorker)
+	if err != nil {
+		return err
+	}
+	defer stopJob()
+	w.Job = job
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	killSignal := make(chan os.Signal, 1)
+	signal.Notify(killSignal, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(killSignal)
+
+	go w.Start(ctx)
+	sig := <-killSignal
+	logrus.Infof("%s signal recieved. exiting...", sig)
+	s.shutdown(cancel, w, killSignal)
+	return nil
+}
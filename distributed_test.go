@@ -0,0 +1,94 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimQueuedTask(t *testing.T) {
+	migrate(t)
+	low, err := models.EnqueueTask(&models.TaskInstance{ID: 1, TaskID: "low", PriorityWeight: 1}, "test", "")
+	assert.Nil(t, err)
+	high, err := models.EnqueueTask(&models.TaskInstance{ID: 2, TaskID: "high", PriorityWeight: 5}, "test", "")
+	assert.Nil(t, err)
+
+	claimed, err := models.ClaimQueuedTask("w1", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, high.ID, claimed.ID)
+	claimed, err = models.ClaimQueuedTask("w2", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, low.ID, claimed.ID)
+	claimed, err = models.ClaimQueuedTask("w3", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, claimed)
+
+	queued, err := high.Heartbeat()
+	assert.Nil(t, err)
+	assert.False(t, queued, "heartbeat from a worker that did not claim the task")
+	high.Worker = "w1"
+	queued, err = high.Heartbeat()
+	assert.Nil(t, err)
+	assert.True(t, queued)
+	assert.Nil(t, high.Delete())
+	queued, err = high.Heartbeat()
+	assert.Nil(t, err)
+	assert.False(t, queued)
+
+	low.Worker = "w2"
+	assert.Nil(t, low.Release())
+	claimed, err = models.ClaimQueuedTask("w3", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, low.ID, claimed.ID)
+	assert.Nil(t, low.Delete())
+}
+
+func TestDistributedExecutor(t *testing.T) {
+	migrate(t)
+	defer func(interval time.Duration) { queuePollInterval = interval }(queuePollInterval)
+	queuePollInterval = 10 * time.Millisecond
+
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.SetDependency("t1", "t2"))
+	assert.Nil(t, dag.SetDependency("t1", "t3"))
+	tasks[2].(*BashOperator).BashCommand = "false"
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queue := NewTaskQueue()
+	for i := 0; i < 2; i++ {
+		go NewWorker(&distributedExecutor{parallelism: 2}).Start(ctx, ctx, queue)
+	}
+	worker := NewQueueWorker(NewDagBag(dag))
+	worker.Concurrency = 2
+	go worker.Start(ctx)
+	defer worker.Wait()
+
+	done := make(chan error)
+	go func() {
+		done <- dag.Run(ctx, NewTaskRunner(dag.tasks, queue), dagRun)
+	}()
+	defer cancel()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("dag run did not finish")
+	}
+
+	taskModels, err := dag.taskModels(dagRun)
+	assert.Nil(t, err)
+	assert.Equal(t, state.Success, taskModels["t1"].State)
+	assert.Equal(t, state.Success, taskModels["t2"].State)
+	assert.Equal(t, state.Failed, taskModels["t3"].State)
+	assert.Contains(t, taskModels["t3"].Message, "exit status 1")
+
+	claimed, err := models.ClaimQueuedTask("w1", 0)
+	assert.Nil(t, err)
+	assert.Nil(t, claimed, "finished tasks are taken off the queue")
+}
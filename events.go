@@ -0,0 +1,103 @@
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EventDagRun type of the events of dag run state transitions
+	EventDagRun = "dagRun"
+	// EventTaskInstance type of the events of task instance state transitions
+	EventTaskInstance = "taskInstance"
+)
+
+// eventBuffer events held for a subscriber before further events to it are dropped
+var eventBuffer = 256
+
+// Event a state transition of a dag run or task instance
+type Event struct {
+	Type      string      `json:"type"`
+	DagID     string      `json:"dagID"`
+	DagRunID  int         `json:"dagRunID"`
+	TaskID    string      `json:"taskID,omitempty"`
+	TryNumber int         `json:"tryNumber,omitempty"`
+	State     state.State `json:"state"`
+	Time      time.Time   `json:"time"`
+}
+
+// EventBus sends the events of the dag runs of a process to its subscribers
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan *Event]struct{}
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: map[chan *Event]struct{}{}}
+}
+
+// events the event bus of the dag runs of this process
+var events = NewEventBus()
+
+// Subscribe receives the events published from now on until unsubscribe is called. Events
+// are dropped for subscribers that fall behind
+func (b *EventBus) Subscribe() (<-chan *Event, func()) {
+	c := make(chan *Event, eventBuffer)
+	b.mu.Lock()
+	b.subscribers[c] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, c)
+			b.mu.Unlock()
+			close(c)
+		})
+	}
+}
+
+// Publish sends an event to every subscriber without waiting on them
+func (b *EventBus) Publish(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+			logrus.Debugf("event subscriber behind, dropped %s %s", e.Type, e.State)
+		}
+	}
+}
+
+// publishDagRun publishes the state of a dag run
+func publishDagRun(dagRun *models.DagRun) {
+	events.Publish(&Event{
+		Type:     EventDagRun,
+		DagID:    dagRun.DagID,
+		DagRunID: dagRun.ID,
+		State:    dagRun.State,
+		Time:     time.Now().UTC(),
+	})
+}
+
+// publishTaskInstance publishes the state of a task instance
+func publishTaskInstance(ti *TaskInstance, s state.State) {
+	if ti.Model == nil || !ti.Task.HasDag() {
+		return
+	}
+	events.Publish(&Event{
+		Type:      EventTaskInstance,
+		DagID:     ti.Task.GetDag().ID,
+		DagRunID:  ti.Model.DagRunID,
+		TaskID:    ti.Task.GetID(),
+		TryNumber: ti.Model.TryNumber,
+		State:     s,
+		Time:      time.Now().UTC(),
+	})
+}
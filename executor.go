@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/tracing"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// SequentialExecutor the executor older configs were written with, which always ran up
+	// to parallelism tasks. It still does, as a local executor
+	SequentialExecutor = "SequentialExecutor"
+	// LocalExecutor runs tasks on goroutines of the scheduler process
+	LocalExecutor = "LocalExecutor"
+	// SubprocessExecutor runs each task in its own process
+	SubprocessExecutor = "SubprocessExecutor"
+	// DistributedExecutor enqueues tasks in the relay database for queue workers
+	DistributedExecutor = "DistributedExecutor"
+)
+
+// Executor runs the task instances workers take from the task queue
+type Executor interface {
+	// Slots number of tasks the executor runs at once
+	Slots() int
+	// Execute runs a task instance and blocks until it finishes. Tasks are stopped when ctx is cancelled
+	Execute(ctx context.Context, ti *TaskInstance) error
+}
+
+// NewExecutor creates an executor by name, as set by executor in the core config
+func NewExecutor(name string) (Executor, error) {
+	parallelism := config.DefaultConfig.Core.Parallelism
+	switch name {
+	case SequentialExecutor:
+		logrus.Warnf("executor %s runs up to parallelism (%d) tasks at once like %s, set executor to %s and parallelism to 1 to run one at a time",
+			SequentialExecutor, parallelism, LocalExecutor, LocalExecutor)
+		return &localExecutor{parallelism: parallelism}, nil
+	case LocalExecutor:
+		return &localExecutor{parallelism: parallelism}, nil
+	case SubprocessExecutor:
+		return &subprocessExecutor{parallelism: parallelism}, nil
+	case DistributedExecutor:
+		return &distributedExecutor{parallelism: parallelism}, nil
+	}
+	return nil, errors.Errorf("unknown executor: %s", name)
+}
+
+// localExecutor runs up to parallelism tasks at once on goroutines of the scheduler process
+type localExecutor struct {
+	parallelism int
+}
+
+func (e *localExecutor) Slots() int { return e.parallelism }
+
+func (e *localExecutor) Execute(ctx context.Context, ti *TaskInstance) error {
+	return ti.run(ctx)
+}
+
+// subprocessExecutor runs up to parallelism tasks at once, each in a process started from
+// the dag binary with the tasks run command. A task that panics or leaks memory only takes
+// down its own process
+type subprocessExecutor struct {
+	parallelism int
+}
+
+func (e *subprocessExecutor) Slots() int { return e.parallelism }
+
+func (e *subprocessExecutor) Execute(ctx context.Context, ti *TaskInstance) error {
+	path, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "dag binary")
+	}
+	cmd := exec.Command(path, taskRunArgs(ti)...)
+	cmd.Env = append(os.Environ(), tracing.Env(ctx)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "start task process")
+	}
+	logrus.Infof("%s running in process %d", ti.FormattedID(), cmd.Process.Pid)
+	ti.Model.PID = cmd.Process.Pid
+	if err := ti.Model.Update(); err != nil {
+		logrus.Error(errors.Wrap(err, "stamp task process"))
+	}
+	return waitCommand(ctx, cmd)
+}
+
+// taskRunArgs arguments of the tasks run command the dag binary is started with to run a
+// single task instance
+func taskRunArgs(ti *TaskInstance) []string {
+	return []string{
+		"tasks", "run",
+		"--run", strconv.Itoa(ti.Model.DagRunID),
+		ti.Task.GetDag().ID, ti.Task.GetID(),
+	}
+}
+
+// RunTask runs a single task of the scheduler's dags in this process, as the tasks run
+// command of a dag binary does for the subprocess executor. The task is stopped on SIGINT
+// or SIGTERM
+func (s *Scheduler) RunTask(dagID, taskID string, dagRunID int) error {
+	dag, ok := s.Dags.Get(dagID)
+	if !ok {
+		return errors.Errorf("could not find dag: %s", dagID)
+	}
+	task, err := dag.getTask(taskID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(contextWithTraceparent(context.Background(), os.Getenv(tracing.EnvTraceparent)))
+	defer cancel()
+	killSignal := make(chan os.Signal, 1)
+	signal.Notify(killSignal, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(killSignal)
+	go func() {
+		select {
+		case <-killSignal:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	logrus.Infof("running %s (run %d) in process %d", task.FormattedID(), dagRunID, os.Getpid())
+	return errors.Wrapf(runLogged(ctx, task, dagRunID, currentTry(dagRunID, task.GetID())), "%s", task.FormattedID())
+}
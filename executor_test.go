@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewExecutor(t *testing.T) {
+	for _, name := range []string{SequentialExecutor, LocalExecutor, SubprocessExecutor} {
+		executor, err := NewExecutor(name)
+		assert.Nil(t, err)
+		assert.Equal(t, config.DefaultConfig.Core.Parallelism, executor.Slots())
+	}
+
+	_, err := NewExecutor("CeleryExecutor")
+	assert.NotNil(t, err)
+}
+
+func TestTaskRunArgs(t *testing.T) {
+	dag, tasks := newTestDag(t, "t1")
+	ti := NewTaskInstance(tasks[0])
+	ti.Model = &models.TaskInstance{DagRunID: 7}
+	assert.Equal(t, []string{"tasks", "run", "--run", "7", dag.ID, "t1"}, taskRunArgs(ti))
+
+	s := NewScheduler()
+	s.Dags.add(dag)
+	assert.Nil(t, s.RunTask(dag.ID, "t1", 7))
+
+	tasks[0].(*BashOperator).BashCommand = "false"
+	assert.NotNil(t, s.RunTask(dag.ID, "t1", 7))
+	assert.NotNil(t, s.RunTask(dag.ID, "missing", 7))
+	assert.NotNil(t, s.RunTask("missing", "t1", 7))
+}
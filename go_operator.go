@@ -1,10 +1,10 @@
 package relay
 
 import (
+	"context"
 	"fmt"
-
-	"github.com/estenssoros/relay/models"
-	"github.com/estenssoros/relay/state"
+	"reflect"
+	"runtime"
 )
 
 // GoOperator operator for go functions
@@ -12,12 +12,13 @@ type GoOperator struct {
 	TaskID            string
 	DAG               *DAG `json:"-"` // avoid recursion
 	Retries           int
+	Pool              string
+	PriorityWeight    int
+	WeightRule        WeightRule
 	Message           string
 	GoFunc            func() error
 	upstreamTaskIDs   []string
 	downstreamTaskIDs []string
-	State             state.State
-	model             *models.TaskInstance
 }
 
 // GetID returns the tag id for an operator
@@ -51,13 +52,13 @@ func (o *GoOperator) addUpstreamTask(taskID string) {
 }
 
 // SetUpstream creates relationships between tasks
-func (o *GoOperator) SetUpstream(task TaskInterface) {
-	setRelatives(o, task, true)
+func (o *GoOperator) SetUpstream(task TaskInterface) error {
+	return setRelatives(o, task, true)
 }
 
 // SetDownStream creates relationships between tasks
-func (o *GoOperator) SetDownStream(task TaskInterface) {
-	setRelatives(o, task, false)
+func (o *GoOperator) SetDownStream(task TaskInterface) error {
+	return setRelatives(o, task, false)
 }
 
 func (o GoOperator) String() string {
@@ -104,28 +105,30 @@ func (o *GoOperator) IsRoot() bool {
 	return !o.hasUpstream()
 }
 
-// Run run the bash operator
-func (o *GoOperator) Run() error {
+// Run run the go function. The function is not interrupted when the context is cancelled
+func (o *GoOperator) Run(ctx context.Context) error {
 	return o.GoFunc()
 }
 
-// SetState sets the state on an operator
-func (o *GoOperator) SetState(s state.State) {
-	o.State = s
-}
-
-// GetState gets the state from an operator
-func (o *GoOperator) GetState() state.State {
-	return o.State
-}
-
 // OperatorType returns the type of the operator
 func (o *GoOperator) OperatorType() string { return `go` }
 
-// SetModel sets a model to the operator
-func (o *GoOperator) SetModel(m *models.TaskInstance) {
-	o.model = m
-}
+// GetPool gets the pool the operator runs in
+func (o *GoOperator) GetPool() string { return o.Pool }
 
-// GetModel gets the model from the operator
-func (o *GoOperator) GetModel() *models.TaskInstance { return o.model }
+// GetPriorityWeight gets the priority weight of the operator
+func (o *GoOperator) GetPriorityWeight() int { return o.PriorityWeight }
+
+// GetWeightRule gets the weight rule of the operator
+func (o *GoOperator) GetWeightRule() WeightRule { return o.WeightRule }
+
+// GetRetries gets the number of times the operator is retried
+func (o *GoOperator) GetRetries() int { return o.Retries }
+
+// serializeParams the key parameters of the operator in the serialized dag
+func (o *GoOperator) serializeParams() map[string]interface{} {
+	if o.GoFunc == nil {
+		return nil
+	}
+	return map[string]interface{}{"goFunc": runtime.FuncForPC(reflect.ValueOf(o.GoFunc).Pointer()).Name()}
+}
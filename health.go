@@ -0,0 +1,130 @@
+package relay
+
+import (
+	"strings"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/models"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// Healthy status of a component that works
+	Healthy = "healthy"
+	// Unhealthy status of a component that does not work
+	Unhealthy = "unhealthy"
+	// Unknown status of a component that could not be checked
+	Unknown = "unknown"
+)
+
+// ComponentHealth status of a component of relay
+type ComponentHealth struct {
+	Status          string                 `json:"status"`
+	Message         string                 `json:"message,omitempty"`
+	LatestHeartbeat *time.Time             `json:"latestHeartbeat,omitempty"`
+	Details         map[string]interface{} `json:"details,omitempty"`
+}
+
+// Health status of relay and each of its components. Relay is unhealthy when any
+// component is
+type Health struct {
+	Status       string           `json:"status"`
+	Metadatabase *ComponentHealth `json:"metadatabase"`
+	Scheduler    *ComponentHealth `json:"scheduler"`
+	Executor     *ComponentHealth `json:"executor"`
+}
+
+// CheckHealth checks the relay database, the heartbeat of the scheduler and the executor of
+// runner. The scheduler is unhealthy when it has not heartbeat within maxAge, three job
+// heartbeats by default. runner is nil outside of the scheduler, which leaves the
+// executor unknown
+func CheckHealth(runner *DagRunner, maxAge time.Duration) *Health {
+	if maxAge <= 0 {
+		maxAge = heartbeatTimeout()
+	}
+	h := &Health{
+		Metadatabase: &ComponentHealth{Status: Healthy},
+		Scheduler:    &ComponentHealth{Status: Unknown},
+		Executor:     executorHealth(runner, maxAge),
+	}
+	if err := db.Ping(); err != nil {
+		h.Metadatabase = &ComponentHealth{Status: Unhealthy, Message: err.Error()}
+		h.Scheduler.Message = "relay database unreachable"
+	} else {
+		h.Scheduler = schedulerHealth(maxAge)
+	}
+	h.Status = Healthy
+	if h.Problems() != "" {
+		h.Status = Unhealthy
+	}
+	return h
+}
+
+// Problems the messages of the unhealthy components, empty when none is
+func (h *Health) Problems() string {
+	problems := []string{}
+	for _, c := range []*ComponentHealth{h.Metadatabase, h.Scheduler, h.Executor} {
+		if c.Status == Unhealthy {
+			problems = append(problems, c.Message)
+		}
+	}
+	return strings.Join(problems, ", ")
+}
+
+// schedulerHealth checks the scheduling loop of the scheduler job that heartbeat last ran
+// within maxAge
+func schedulerHealth(maxAge time.Duration) *ComponentHealth {
+	job, err := models.LatestJob(JobScheduler)
+	if gorm.IsRecordNotFoundError(err) {
+		return &ComponentHealth{Status: Unhealthy, Message: "no scheduler running"}
+	}
+	if err != nil {
+		return &ComponentHealth{Status: Unknown, Message: err.Error()}
+	}
+	loop := job.LatestLoop.UTC()
+	c := &ComponentHealth{
+		Status:          Healthy,
+		LatestHeartbeat: &loop,
+		Details:         map[string]interface{}{"jobID": job.ID, "hostName": job.HostName, "pid": job.PID},
+	}
+	if age := time.Since(loop); age > maxAge {
+		c.Status = Unhealthy
+		c.Message = "scheduling loop is stale, last ran " + age.Round(time.Second).String() + " ago"
+	}
+	return c
+}
+
+// executorHealth checks the executor of a dag runner has workers. The distributed
+// executor also needs queue workers that heartbeat within maxAge
+func executorHealth(runner *DagRunner, maxAge time.Duration) *ComponentHealth {
+	if runner == nil {
+		return &ComponentHealth{Status: Unknown, Message: "tasks are run by the scheduler process"}
+	}
+	c := &ComponentHealth{
+		Status: Healthy,
+		Details: map[string]interface{}{
+			"executor": config.DefaultConfig.Core.Executor,
+			"slots":    runner.Executor.Slots(),
+			"workers":  int(workerSlots.Value()),
+			"busy":     int(workersBusy.Value()),
+		},
+	}
+	if workerSlots.Value() == 0 {
+		c.Status, c.Message = Unhealthy, "no workers taking tasks"
+		return c
+	}
+	if _, ok := runner.Executor.(*distributedExecutor); ok {
+		live, err := models.CountLiveJobs(JobWorker, maxAge)
+		if err != nil {
+			c.Status, c.Message = Unknown, err.Error()
+			return c
+		}
+		c.Details["queueWorkers"] = live
+		if live == 0 {
+			c.Status, c.Message = Unhealthy, "no queue workers heartbeating"
+		}
+	}
+	return c
+}
@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckHealth(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job, err := models.NewJob(JobScheduler)
+	assert.Nil(t, err)
+	defer job.End()
+
+	health := CheckHealth(nil, 0)
+	assert.Equal(t, Healthy, health.Status, health.Problems())
+	assert.Equal(t, Healthy, health.Metadatabase.Status)
+	assert.Equal(t, Healthy, health.Scheduler.Status)
+	assert.NotNil(t, health.Scheduler.LatestHeartbeat)
+	assert.Equal(t, Unknown, health.Executor.Status)
+
+	runner := NewDagRunner()
+	runner.Executor = &localExecutor{parallelism: 1}
+	assert.Equal(t, Unhealthy, CheckHealth(runner, 0).Executor.Status, "no workers")
+	go NewWorker(runner.Executor).Start(ctx, ctx, NewTaskQueue())
+	for workerSlots.Value() == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("worker did not start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	health = CheckHealth(runner, 0)
+	assert.Equal(t, Healthy, health.Executor.Status, health.Problems())
+	assert.Equal(t, 1, health.Executor.Details["slots"])
+
+	stale := time.Now().UTC().Add(-2 * heartbeatTimeout())
+	assert.Nil(t, job.HeartbeatLoop(stale), "heartbeats while its loop is stuck")
+	health = CheckHealth(runner, 0)
+	assert.Equal(t, Unhealthy, health.Status)
+	assert.Equal(t, Unhealthy, health.Scheduler.Status)
+	assert.Contains(t, health.Problems(), "scheduling loop is stale")
+	assert.Equal(t, Healthy, CheckHealth(runner, 3*heartbeatTimeout()).Status, "within max age")
+
+	e := echo.New()
+	w := NewWebserver(NewDagBag())
+	w.Runner = runner
+	w.Routes(e)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	body := &Health{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), body))
+	assert.Equal(t, Unhealthy, body.Status)
+	assert.Equal(t, Healthy, body.Metadatabase.Status)
+	assert.Equal(t, Healthy, body.Executor.Status)
+
+	assert.Nil(t, job.HeartbeatLoop(time.Now()))
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
+
+func TestSchedulerLoopLiveness(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewScheduler()
+	go s.schedule(ctx, make(chan *scheduledRun))
+	time.Sleep(2*heartbeatInterval() + 100*time.Millisecond)
+	assert.True(t, time.Since(latestSchedulerLoop()) <= heartbeatInterval(), "idle loop runs every heartbeat")
+
+	assert.Nil(t, s.AddDag(newSecondlyDag(t, "stuck-"+time.Now().Format(time.RFC3339Nano))))
+	time.Sleep(3 * heartbeatInterval())
+	assert.True(t, time.Since(latestSchedulerLoop()) > heartbeatInterval(), "loop stuck sending a run")
+	collectMetrics(s.Dags, nil)
+	assert.True(t, schedulerHeartbeatLag.Value() > heartbeatInterval().Seconds())
+}
@@ -1,13 +1,13 @@
 package relay
 
 import (
-	"errors"
+	"context"
 
-	"github.com/estenssoros/relay/models"
-	"github.com/estenssoros/relay/state"
+	"github.com/pkg/errors"
 )
 
-// TaskInterface an interface for all operators on a DAG
+// TaskInterface an interface for all operators on a DAG. Operators are definitions shared
+// by every run of a dag, the state of a task in a run is held by its TaskInstance
 type TaskInterface interface {
 	String() string
 	GetID() string
@@ -20,13 +20,13 @@ type TaskInterface interface {
 	HasDag() bool
 	addDownstreamTask(string)
 	addUpstreamTask(string)
-	SetState(state.State)
-	GetState() state.State
 	IsRoot() bool
-	Run() error
+	Run(context.Context) error
 	OperatorType() string
-	SetModel(*models.TaskInstance)
-	GetModel() *models.TaskInstance
+	GetPool() string
+	GetPriorityWeight() int
+	GetWeightRule() WeightRule
+	GetRetries() int
 }
 
 func setRelatives(task, other TaskInterface, upstream bool) error {
@@ -37,31 +37,60 @@ func setRelatives(task, other TaskInterface, upstream bool) error {
 		other.SetDag(task.GetDag())
 	}
 	if upstream {
-		other.addDownstreamTask(task.GetID())
-		task.addUpstreamTask(other.GetID())
-		return nil
+		return addDependency(other, task)
 	}
-	task.addDownstreamTask(other.GetID())
-	other.addUpstreamTask(task.GetID())
+	return addDependency(task, other)
+}
+
+// addDependency makes downstream depend on upstream. Self references, duplicate
+// edges and edges that would introduce a cycle are rejected.
+func addDependency(upstream, downstream TaskInterface) error {
+	if upstream.GetID() == downstream.GetID() {
+		return errors.Errorf("task %s cannot depend on itself", upstream.GetID())
+	}
+	for _, t := range upstream.downstreamList() {
+		if t.GetID() == downstream.GetID() {
+			return errors.Errorf("dependency %s >> %s already exists", upstream.GetID(), downstream.GetID())
+		}
+	}
+	if hasPath(downstream, upstream) {
+		return errors.Errorf("dependency %s >> %s would create a cycle", upstream.GetID(), downstream.GetID())
+	}
+	upstream.addDownstreamTask(downstream.GetID())
+	downstream.addUpstreamTask(upstream.GetID())
 	return nil
 }
 
-func upstreamList(task TaskInterface) []TaskInterface {
-	lst := []TaskInterface{}
-	for _, taskID := range task.upstreamList() {
-		task, err := task.GetDag().getTask(taskID.String())
-		if err != nil {
+// hasPath checks if to can be reached from from by following downstream tasks
+func hasPath(from, to TaskInterface) bool {
+	return reaches(from, to, map[string]bool{})
+}
+
+// reaches walks the downstream tasks of from once each, skipping the tasks in seen
+func reaches(from, to TaskInterface, seen map[string]bool) bool {
+	if from.GetID() == to.GetID() {
+		return true
+	}
+	seen[from.GetID()] = true
+	for _, t := range from.downstreamList() {
+		if seen[t.GetID()] {
 			continue
 		}
-		lst = append(lst, task)
+		if reaches(t, to, seen) {
+			return true
+		}
 	}
-	return lst
+	return false
 }
 
-func downstreamList(task TaskInterface) []TaskInterface {
+// taskList resolves a list of task ids against a dag
+func taskList(dag *DAG, taskIDs []string) []TaskInterface {
 	lst := []TaskInterface{}
-	for _, taskID := range task.downstreamList() {
-		task, err := task.GetDag().getTask(taskID.String())
+	if dag == nil {
+		return lst
+	}
+	for _, taskID := range taskIDs {
+		task, err := dag.getTask(taskID)
 		if err != nil {
 			continue
 		}
@@ -0,0 +1,77 @@
+package relay
+
+import (
+	"context"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// JobScheduler job type of a scheduler process
+	JobScheduler = "scheduler"
+	// JobWorker job type of a queue worker process
+	JobWorker = "worker"
+)
+
+// heartbeatInterval how often jobs, and workers running tasks, heartbeat
+func heartbeatInterval() time.Duration {
+	if sec := config.DefaultConfig.Scheduler.JobHeartBeatSec; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 5 * time.Second
+}
+
+// heartbeatTimeout how long a job or worker can go without heartbeating before it is
+// considered stopped
+func heartbeatTimeout() time.Duration {
+	return 3 * heartbeatInterval()
+}
+
+// startJob records this process as a job of jobType and heartbeats it until the returned
+// stop func is called, which ends the job. Scheduler jobs also record when their
+// scheduling loop last ran, so a stuck loop shows even though the job heartbeats
+func startJob(jobType string) (*models.Job, func(), error) {
+	job, err := models.NewJob(jobType)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "new job")
+	}
+	logrus.Infof("started %s job %d on %s (PID: %d)", jobType, job.ID, job.HostName, job.PID)
+	if jobType == JobScheduler {
+		recordSchedulerLoop(job.LatestLoop)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(heartbeatInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				var err error
+				if jobType != JobScheduler {
+					err = job.Heartbeat()
+				} else if err = job.HeartbeatLoop(latestSchedulerLoop()); err == nil {
+					recordSchedulerHeartbeat()
+				}
+				if err != nil {
+					logrus.Error(errors.Wrap(err, "job heartbeat"))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	stop := func() {
+		cancel()
+		<-stopped
+		if err := job.End(); err != nil {
+			logrus.Error(errors.Wrap(err, "end job"))
+		}
+	}
+	return job, stop, nil
+}
@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// schedulerLease name of the lease held by the leading scheduler
+var schedulerLease = "scheduler"
+
+// leaseTTL how long the leading scheduler holds the lease without renewing it. Followers
+// take over within this long of the leader stopping
+func leaseTTL() time.Duration {
+	if sec := config.DefaultConfig.Scheduler.LeaderLeaseSec; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 30 * time.Second
+}
+
+// leader elects the leading scheduler through the scheduler lease. Only the leader
+// schedules and runs dags. Followers wait to take over until the lease expires
+type leader struct {
+	holder string
+	stop   context.CancelFunc
+	done   chan struct{}
+}
+
+// newLeader creates a leader election for a scheduler job
+func newLeader(job *models.Job) *leader {
+	return &leader{holder: fmt.Sprintf("job %d on %s (PID: %d)", job.ID, job.HostName, job.PID)}
+}
+
+// acquire blocks until the lease is taken. Returns false if a kill signal arrives first
+func (l *leader) acquire(killSignal <-chan os.Signal) bool {
+	ticker := time.NewTicker(leaseTTL() / 3)
+	defer ticker.Stop()
+	following := ""
+	for {
+		ok, err := models.AcquireLease(schedulerLease, l.holder, leaseTTL())
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "acquire scheduler lease"))
+		} else if ok {
+			logrus.Infof("%s is the leading scheduler", l.holder)
+			return true
+		} else if lease, err := models.GetLease(schedulerLease); err == nil && lease.Holder != following {
+			following = lease.Holder
+			logrus.Infof("following the leading scheduler, %s", following)
+		}
+		select {
+		case <-ticker.C:
+		case sig := <-killSignal:
+			logrus.Infof("%s signal recieved. exiting...", sig)
+			return false
+		}
+	}
+}
+
+// keep renews the lease until released. The returned channel is closed if the lease is
+// lost: taken by another scheduler, or not renewed for two thirds of its ttl. The leader
+// steps down then, a third of the ttl before the lease can expire and a follower take over
+func (l *leader) keep() <-chan struct{} {
+	ctx, stop := context.WithCancel(context.Background())
+	l.stop = stop
+	l.done = make(chan struct{})
+	lost := make(chan struct{})
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(leaseTTL() / 3)
+		defer ticker.Stop()
+		stepDown := time.NewTimer(leaseTTL() - leaseTTL()/3)
+		defer stepDown.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// the lease runs from before the renewal was sent, not from when it was stored
+				renewing := time.Now()
+				ok, err := models.AcquireLease(schedulerLease, l.holder, leaseTTL())
+				if err != nil {
+					logrus.Error(errors.Wrap(err, "renew scheduler lease"))
+					continue
+				}
+				if !ok {
+					close(lost)
+					return
+				}
+				if !stepDown.Stop() {
+					<-stepDown.C
+				}
+				stepDown.Reset(leaseTTL() - leaseTTL()/3 - time.Since(renewing))
+			case <-stepDown.C:
+				logrus.Error("scheduler lease not renewed in time, stepping down before it expires")
+				close(lost)
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lost
+}
+
+// release stops renewing the lease and gives it up so a follower takes over right away
+func (l *leader) release() {
+	if l.stop != nil {
+		l.stop()
+		<-l.done
+	}
+	if err := models.ReleaseLease(schedulerLease, l.holder); err != nil {
+		logrus.Error(errors.Wrap(err, "release scheduler lease"))
+	}
+}
@@ -0,0 +1,70 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLease(t *testing.T) {
+	migrate(t)
+	name := "test-" + time.Now().Format(time.RFC3339Nano)
+	ttl := 200 * time.Millisecond
+
+	ok, err := models.AcquireLease(name, "a", ttl)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = models.AcquireLease(name, "b", ttl)
+	assert.Nil(t, err)
+	assert.False(t, ok, "lease held by a")
+	ok, err = models.AcquireLease(name, "a", ttl)
+	assert.Nil(t, err)
+	assert.True(t, ok, "renew")
+
+	time.Sleep(ttl + 50*time.Millisecond)
+	ok, err = models.AcquireLease(name, "b", ttl)
+	assert.Nil(t, err)
+	assert.True(t, ok, "take over expired lease")
+	ok, err = models.AcquireLease(name, "a", ttl)
+	assert.Nil(t, err)
+	assert.False(t, ok, "lease held by b")
+
+	assert.Nil(t, models.ReleaseLease(name, "b"))
+	ok, err = models.AcquireLease(name, "a", ttl)
+	assert.Nil(t, err)
+	assert.True(t, ok, "take over released lease")
+}
+
+func TestDatabaseNow(t *testing.T) {
+	now, err := db.Now()
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, now.Location())
+	assert.WithinDuration(t, time.Now(), now, 5*time.Second)
+}
+
+func TestScheduleDagOncePerExecutionDate(t *testing.T) {
+	migrate(t)
+	dag, _ := newTestDag(t, "t1")
+	dag.ID = "once-" + time.Now().Format(time.RFC3339Nano)
+	executionDate := time.Now().UTC().Truncate(time.Minute)
+
+	dagRun := dag.DagRunAt(executionDate)
+	assert.Nil(t, dagRun.Create())
+	assert.NotNil(t, dag.DagRunAt(executionDate).Create(), "unique dag id and execution date")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := NewDagRunner()
+	go runner.Run(ctx)
+	runner.ScheduleDag(dag, executionDate)
+	runner.ScheduleDag(dag, executionDate.Add(time.Minute))
+	runner.ScheduleDag(dag, executionDate.Add(time.Minute))
+
+	count := 0
+	assert.Nil(t, db.Connection.Model(&models.DagRun{}).Where("dag_id = ?", dag.ID).Count(&count).Error)
+	assert.Equal(t, 2, count)
+}
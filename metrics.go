@@ -0,0 +1,139 @@
+package relay
+
+import (
+	"sync"
+	"time"
+
+	"github.com/estenssoros/relay/metrics"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	schedulerHeartbeats = metrics.NewCounter("relay_scheduler_heartbeats_total",
+		"Heartbeats of the scheduler job of this process.")
+	schedulerHeartbeatLag = metrics.NewGauge("relay_scheduler_heartbeat_lag_seconds",
+		"Seconds since the scheduling loop of this process last ran.")
+	dagRegistrations = metrics.NewCounter("relay_dag_registrations_total",
+		"Dags added to the scheduler, by result.", "result")
+	dagLoads = metrics.NewCounter("relay_dag_loads_total",
+		"Dags loaded from their serialized versions by the standalone webserver, by result.", "result")
+	dagsLoaded = metrics.NewGauge("relay_dags",
+		"Dags in the dag bag.")
+	dagRunsActive = metrics.NewGauge("relay_dag_runs_active",
+		"Running dag runs, by dag.", "dag_id")
+	dagRunsQueued = metrics.NewGauge("relay_dag_runs_queued",
+		"Dag runs waiting on max active runs, by dag.", "dag_id")
+	dagRunsFinished = metrics.NewCounter("relay_dag_runs_total",
+		"Finished dag runs, by dag and state.", "dag_id", "state")
+	taskInstances = metrics.NewGauge("relay_task_instances",
+		"Queued and running task instances, by dag and state.", "dag_id", "state")
+	taskDuration = metrics.NewHistogram("relay_task_duration_seconds",
+		"Duration of task tries, by operator and state.", metrics.DefaultBuckets, "operator", "state")
+	workerSlots = metrics.NewGauge("relay_workers",
+		"Workers taking task instances from the task queue.")
+	workersBusy = metrics.NewGauge("relay_workers_busy",
+		"Workers running a task instance.")
+	workerUtilization = metrics.NewGauge("relay_worker_utilization",
+		"Share of the workers running a task instance.")
+)
+
+func init() {
+	if err := registerMetrics(metrics.Default); err != nil {
+		logrus.Error(errors.Wrap(err, "register metrics"))
+	}
+}
+
+// registerMetrics registers the metrics of relay, which are served at /metrics
+func registerMetrics(r *metrics.Registry) error {
+	return r.Register(
+		schedulerHeartbeats,
+		schedulerHeartbeatLag,
+		dagRegistrations,
+		dagLoads,
+		dagsLoaded,
+		dagRunsActive,
+		dagRunsQueued,
+		dagRunsFinished,
+		taskInstances,
+		taskDuration,
+		workerSlots,
+		workersBusy,
+		workerUtilization,
+	)
+}
+
+var (
+	loopMu   sync.Mutex
+	lastLoop time.Time
+)
+
+// recordSchedulerHeartbeat records a heartbeat of the scheduler job
+func recordSchedulerHeartbeat() {
+	schedulerHeartbeats.Inc()
+}
+
+// recordSchedulerLoop records a run of the scheduling loop
+func recordSchedulerLoop(at time.Time) {
+	loopMu.Lock()
+	lastLoop = at
+	loopMu.Unlock()
+}
+
+// latestSchedulerLoop when the scheduling loop last ran
+func latestSchedulerLoop() time.Time {
+	loopMu.Lock()
+	defer loopMu.Unlock()
+	return lastLoop
+}
+
+// recordRegistration records a dag added to the scheduler
+func recordRegistration(err error) {
+	dagRegistrations.Inc(result(err))
+}
+
+// recordDagRun records a finished dag run
+func recordDagRun(dagRun *models.DagRun) {
+	dagRunsFinished.Inc(dagRun.DagID, string(dagRun.State))
+}
+
+// recordTry records a try of a task instance that came back from a worker
+func recordTry(ti *TaskInstance) {
+	taskDuration.Observe(ti.Model.Duration, ti.Task.OperatorType(), string(ti.Model.State))
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// collectMetrics sets the gauges that are read from the dags and dag runner when metrics
+// are scraped. runner is nil in the standalone webserver
+func collectMetrics(dags *DagBag, runner *DagRunner) {
+	if loop := latestSchedulerLoop(); !loop.IsZero() {
+		schedulerHeartbeatLag.Set(time.Since(loop).Seconds())
+	}
+
+	list := dags.List()
+	dagsLoaded.Set(float64(len(list)))
+	if runner == nil {
+		return
+	}
+	dagRunsActive.Reset()
+	dagRunsQueued.Reset()
+	taskInstances.Reset()
+	for _, dag := range list {
+		status := runner.Status(dag)
+		dagRunsActive.Set(float64(status.ActiveRuns), dag.ID)
+		dagRunsQueued.Set(float64(status.QueuedRuns), dag.ID)
+		taskInstances.Set(float64(status.QueuedTasks), dag.ID, string(state.Queued))
+		taskInstances.Set(float64(status.RunningTasks), dag.ID, string(state.Running))
+	}
+	if slots := workerSlots.Value(); slots > 0 {
+		workerUtilization.Set(workersBusy.Value() / slots)
+	}
+}
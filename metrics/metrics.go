@@ -0,0 +1,385 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultBuckets upper bounds in seconds of the buckets of duration histograms
+var DefaultBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Registry metrics written together in the prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []Metric
+	names   map[string]bool
+	sink    Sink
+}
+
+// Metric a family of series with the same name and label names: a counter, gauge or
+// histogram
+type Metric interface {
+	name() string
+	setRegistry(r *Registry) error
+	write(w *bufio.Writer)
+}
+
+// Sink receives every update of the metrics of a registry to push them elsewhere. Label
+// values are in the order of the metric's label names
+type Sink interface {
+	Count(name string, values []string, v float64)
+	Gauge(name string, values []string, v float64)
+	Observe(name string, values []string, v float64)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// Default the registry of the metrics of this process
+var Default = NewRegistry()
+
+// Register adds metrics to the registry. Metrics are written in the order they are
+// registered. Fails on a name that is already registered or a metric that is in another
+// registry, and then registers none of the metrics
+func (r *Registry) Register(metrics ...Metric) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := map[string]bool{}
+	for _, m := range metrics {
+		if r.names[m.name()] || names[m.name()] {
+			return errors.Errorf("metric %s registered twice", m.name())
+		}
+		names[m.name()] = true
+	}
+	for i, m := range metrics {
+		if err := m.setRegistry(r); err != nil {
+			for _, registered := range metrics[:i] {
+				registered.setRegistry(nil)
+			}
+			return err
+		}
+	}
+	for _, m := range metrics {
+		r.names[m.name()] = true
+		r.metrics = append(r.metrics, m)
+	}
+	return nil
+}
+
+// SetSink sends the updates of the registry's metrics to a sink, or stops sending them for
+// a nil sink
+func (r *Registry) SetSink(s Sink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sink = s
+}
+
+func (r *Registry) getSink() Sink {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sink
+}
+
+// Write writes the metrics of the registry in the prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]Metric{}, r.metrics...)
+	r.mu.Unlock()
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return errors.Wrap(buf.Flush(), "write metrics")
+}
+
+// ContentType content type of the prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family the series of a metric by label values
+type family struct {
+	mu         sync.Mutex
+	registry   *Registry
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	series     map[string][]string
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{metricName: name, help: help, kind: kind, labels: labels, series: map[string][]string{}}
+}
+
+func (f *family) name() string { return f.metricName }
+
+func (f *family) setRegistry(r *Registry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r != nil && f.registry != nil {
+		return errors.Errorf("metric %s is already in a registry", f.metricName)
+	}
+	f.registry = r
+	return nil
+}
+
+// check fails when label values do not match the label names of the family
+func (f *family) check(values []string) error {
+	if len(values) != len(f.labels) {
+		return errors.Errorf("metric %s has labels %v, got values %v", f.metricName, f.labels, values)
+	}
+	return nil
+}
+
+// key the key of the series with label values, which are added to the family
+func (f *family) key(values []string) string {
+	k := strings.Join(values, "\xff")
+	if _, ok := f.series[k]; !ok {
+		f.series[k] = append([]string{}, values...)
+	}
+	return k
+}
+
+// keys the keys of the family's series, sorted
+func (f *family) keys() []string {
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// header writes the help and type lines of the family
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.metricName, strings.Replace(f.help, "\n", " ", -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.metricName, f.kind)
+}
+
+// sample writes a line of a series
+func (f *family) sample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	pairs := []string{}
+	for i, label := range f.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	w.WriteString(f.metricName + suffix)
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatValue(v) + "\n")
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter a count that only goes up, by label values
+type Counter struct {
+	family
+	values map[string]float64
+}
+
+// NewCounter creates a counter. It is written once registered
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{family: newFamily(name, help, "counter", labels), values: map[string]float64{}}
+}
+
+// Inc adds one to the series with label values
+func (c *Counter) Inc(values ...string) error {
+	return c.Add(1, values...)
+}
+
+// Add adds v to the series with label values. Fails when the values do not match the
+// counter's labels
+func (c *Counter) Add(v float64, values ...string) error {
+	if err := c.check(values); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.values[c.key(values)] += v
+	registry := c.registry
+	c.mu.Unlock()
+	if sink := registry.getSink(); sink != nil {
+		sink.Count(c.metricName, values, v)
+	}
+	return nil
+}
+
+// Value the count of the series with label values
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(values, "\xff")]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range c.keys() {
+		c.sample(w, "", c.series[k], "", c.values[k])
+	}
+}
+
+// Gauge a value that goes up and down, by label values
+type Gauge struct {
+	family
+	values map[string]float64
+}
+
+// NewGauge creates a gauge. It is written once registered
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{family: newFamily(name, help, "gauge", labels), values: map[string]float64{}}
+}
+
+// Set sets the series with label values to v. Fails when the values do not match the
+// gauge's labels
+func (g *Gauge) Set(v float64, values ...string) error {
+	if err := g.check(values); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.values[g.key(values)] = v
+	registry := g.registry
+	g.mu.Unlock()
+	if sink := registry.getSink(); sink != nil {
+		sink.Gauge(g.metricName, values, v)
+	}
+	return nil
+}
+
+// Add adds v, which may be negative, to the series with label values. Fails when the
+// values do not match the gauge's labels
+func (g *Gauge) Add(v float64, values ...string) error {
+	if err := g.check(values); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	k := g.key(values)
+	g.values[k] += v
+	v = g.values[k]
+	registry := g.registry
+	g.mu.Unlock()
+	if sink := registry.getSink(); sink != nil {
+		sink.Gauge(g.metricName, values, v)
+	}
+	return nil
+}
+
+// Value the value of the series with label values
+func (g *Gauge) Value(values ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[strings.Join(values, "\xff")]
+}
+
+// Reset removes every series, for gauges set from a snapshot of things that come and go
+func (g *Gauge) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = map[string]float64{}
+	g.series = map[string][]string{}
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, k := range g.keys() {
+		g.sample(w, "", g.series[k], "", g.values[k])
+	}
+}
+
+// Histogram counts observations in buckets by their upper bound, by label values
+type Histogram struct {
+	family
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram creates a histogram with sorted bucket upper bounds. It is written once
+// registered
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{
+		family:  newFamily(name, help, "histogram", labels),
+		buckets: buckets,
+		counts:  map[string][]uint64{},
+		sums:    map[string]float64{},
+		totals:  map[string]uint64{},
+	}
+}
+
+// Observe adds an observation to the series with label values. Fails when the values do
+// not match the histogram's labels
+func (h *Histogram) Observe(v float64, values ...string) error {
+	if err := h.check(values); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	k := h.key(values)
+	if _, ok := h.counts[k]; !ok {
+		h.counts[k] = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[k][i]++
+		}
+	}
+	h.sums[k] += v
+	h.totals[k]++
+	registry := h.registry
+	h.mu.Unlock()
+	if sink := registry.getSink(); sink != nil {
+		sink.Observe(h.metricName, values, v)
+	}
+	return nil
+}
+
+// Count the number of observations of the series with label values
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.totals[strings.Join(values, "\xff")]
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range h.keys() {
+		values := h.series[k]
+		for i, bound := range h.buckets {
+			h.sample(w, "_bucket", values, `le="`+formatValue(bound)+`"`, float64(h.counts[k][i]))
+		}
+		h.sample(w, "_bucket", values, `le="+Inf"`, float64(h.totals[k]))
+		h.sample(w, "_sum", values, "", h.sums[k])
+		h.sample(w, "_count", values, "", float64(h.totals[k]))
+	}
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	defaultRegistry := Default
+	Default = NewRegistry()
+	defer func() { Default = defaultRegistry }()
+
+	runs := NewCounter("runs_total", "Finished runs.", "dag_id", "state")
+	workers := NewGauge("workers", "Workers.")
+	duration := NewHistogram("duration_seconds", "Task durations.", []float64{1, 10}, "operator")
+	assert.Nil(t, Default.Register(runs, workers, duration))
+	assert.Nil(t, runs.Inc("b", "success"))
+	assert.Nil(t, runs.Inc("a", "failed"))
+	assert.Nil(t, runs.Add(2, "b", "success"))
+	assert.Nil(t, workers.Set(4))
+	assert.Nil(t, workers.Add(-1))
+	assert.Nil(t, duration.Observe(0.5, `say "hi"`))
+	assert.Nil(t, duration.Observe(5, `say "hi"`))
+	assert.Nil(t, duration.Observe(50, `say "hi"`))
+
+	assert.NotNil(t, Default.Register(NewCounter("rows_total", "Rows."), NewGauge("workers", "again")))
+	assert.NotNil(t, NewRegistry().Register(runs), "already in a registry")
+	assert.NotNil(t, runs.Inc("a"), "missing label value")
+	assert.NotNil(t, workers.Set(1, "extra"))
+	assert.NotNil(t, duration.Observe(1))
+
+	b := &bytes.Buffer{}
+	assert.Nil(t, Default.Write(b))
+	assert.Equal(t, `# HELP runs_total Finished runs.
+# TYPE runs_total counter
+runs_total{dag_id="a",state="failed"} 1
+runs_total{dag_id="b",state="success"} 3
+# HELP workers Workers.
+# TYPE workers gauge
+workers 3
+# HELP duration_seconds Task durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{operator="say \"hi\"",le="1"} 1
+duration_seconds_bucket{operator="say \"hi\"",le="10"} 2
+duration_seconds_bucket{operator="say \"hi\"",le="+Inf"} 3
+duration_seconds_sum{operator="say \"hi\""} 55.5
+duration_seconds_count{operator="say \"hi\""} 3
+`, b.String())
+
+	workers.Reset()
+	assert.Equal(t, float64(0), workers.Value())
+	assert.Equal(t, uint64(3), duration.Count(`say "hi"`))
+}
+
+func TestStatsD(t *testing.T) {
+	defaultRegistry := Default
+	Default = NewRegistry()
+	defer func() { Default = defaultRegistry }()
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	statsd, err := NewStatsD(server.LocalAddr().String(), "relay")
+	assert.Nil(t, err)
+	defer statsd.Close()
+	Default.SetSink(statsd)
+
+	runs := NewCounter("relay_dag_runs_total", "Finished runs.", "dag_id", "state")
+	workers := NewGauge("relay_workers_busy", "Busy workers.")
+	duration := NewHistogram("relay_task_duration_seconds", "Task durations.", DefaultBuckets, "operator")
+	rows := NewHistogram("relay_rows", "Rows.", []float64{10})
+	assert.Nil(t, Default.Register(runs, workers, duration, rows))
+	runs.Inc("etl.daily", "success")
+	workers.Add(2)
+	workers.Add(-1)
+	duration.Observe(1.5, "bash")
+	rows.Observe(3)
+
+	packets := []string{}
+	b := make([]byte, 1024)
+	for i := 0; i < 5; i++ {
+		server.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := server.ReadFrom(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, string(b[:n]))
+	}
+	assert.Equal(t, []string{
+		"relay.dag_runs_total.etl_daily.success:1|c",
+		"relay.workers_busy:2|g",
+		"relay.workers_busy:1|g",
+		"relay.task_duration_seconds.bash:1500|ms",
+		"relay.rows:3|h",
+	}, packets)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StatsD sends metric updates to a statsd server over udp. Label values are appended to
+// the name of a stat: relay_dag_runs_total{dag_id="etl",state="success"} with the prefix
+// relay is sent as relay.dag_runs_total.etl.success. Observations of metrics in seconds
+// are sent as timers in milliseconds
+type StatsD struct {
+	prefix string
+	conn   net.Conn
+}
+
+// NewStatsD creates a statsd sink sending to addr with a prefix for the stat names
+func NewStatsD(addr, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial statsd")
+	}
+	return &StatsD{prefix: prefix, conn: conn}, nil
+}
+
+// Close closes the connection to the statsd server
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// Count sends a counter increment
+func (s *StatsD) Count(name string, values []string, v float64) {
+	s.send(name, values, v, "c")
+}
+
+// Gauge sends the value of a gauge
+func (s *StatsD) Gauge(name string, values []string, v float64) {
+	s.send(name, values, v, "g")
+}
+
+// Observe sends a timer for metrics in seconds and a histogram value otherwise
+func (s *StatsD) Observe(name string, values []string, v float64) {
+	if strings.HasSuffix(name, "_seconds") {
+		s.send(name, values, v*1000, "ms")
+		return
+	}
+	s.send(name, values, v, "h")
+}
+
+var unsafeStat = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+
+// Stat the statsd name of a series
+func (s *StatsD) Stat(name string, values []string) string {
+	parts := []string{}
+	if s.prefix != "" {
+		parts = append(parts, s.prefix)
+		name = strings.TrimPrefix(name, s.prefix+"_")
+	}
+	parts = append(parts, name)
+	for _, v := range values {
+		parts = append(parts, unsafeStat.ReplaceAllString(v, "_"))
+	}
+	return strings.Join(parts, ".")
+}
+
+// send writes a stat without waiting on the server. Stats are dropped when it is down
+func (s *StatsD) send(name string, values []string, v float64, kind string) {
+	fmt.Fprintf(s.conn, "%s:%s|%s", s.Stat(name, values), strconv.FormatFloat(v, 'f', -1, 64), kind)
+}
@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/metrics"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dag, tasks := newTestDag(t, "t1", "t2")
+	dag.ID = "metrics-" + time.Now().Format(time.RFC3339Nano)
+	assert.Nil(t, dag.Chain(tasks...))
+
+	registered := dagRegistrations.Value("success")
+	failed := dagRegistrations.Value("error")
+	s := NewScheduler()
+	assert.Nil(t, s.AddDag(dag))
+	assert.NotNil(t, s.AddDag(dag), "registered twice")
+	assert.Equal(t, registered+1, dagRegistrations.Value("success"))
+	assert.Equal(t, failed+1, dagRegistrations.Value("error"))
+
+	tries := taskDuration.Count("bash", string(state.Success))
+	runner := NewDagRunner()
+	runner.Executor = &localExecutor{parallelism: 2}
+	go runner.Run(ctx)
+	runner.RunDag(dag)
+	for dagRunsFinished.Value(dag.ID, string(state.Success)) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("dag run did not finish")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Equal(t, tries+2, taskDuration.Count("bash", string(state.Success)))
+
+	authenticate := config.DefaultConfig.Webserver.Authenticate
+	config.DefaultConfig.Webserver.Authenticate = true
+	defer func() { config.DefaultConfig.Webserver.Authenticate = authenticate }()
+	e := echo.New()
+	w := NewWebserver(s.Dags)
+	w.Runner = runner
+	w.Routes(e)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code, "scraped without a user")
+	assert.Equal(t, metrics.ContentType, rec.Header().Get(echo.HeaderContentType))
+	body := rec.Body.String()
+	assert.Contains(t, body, fmt.Sprintf(`relay_dag_runs_total{dag_id="%s",state="success"} 1`, dag.ID))
+	assert.Contains(t, body, fmt.Sprintf(`relay_dag_runs_active{dag_id="%s"} 0`, dag.ID))
+	assert.Contains(t, body, fmt.Sprintf(`relay_task_instances{dag_id="%s",state="running"} 0`, dag.ID))
+	assert.Contains(t, body, "relay_dags 1\n")
+	assert.Contains(t, body, `relay_task_duration_seconds_count{operator="bash",state="success"}`)
+	assert.Contains(t, body, "# TYPE relay_workers_busy gauge")
+	assert.Contains(t, body, "# TYPE relay_worker_utilization gauge")
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"github.com/estenssoros/relay/db"
 )
 
 // DAG (directed acyclic graph) a collection of tasks with directional
@@ -12,4 +14,23 @@ type DAG struct {
 	LastSchedulerRun time.Time
 	Description      string
 	ScheduleInterval string
+	Timezone         string
+	Hash             string
+}
+
+// SetDagPaused pauses or unpauses a dag. The scheduler skips the runs of paused dags
+func SetDagPaused(id string, paused bool) error {
+	return db.Connection.Model(&DAG{ID: id}).Update("is_paused", paused).Error
+}
+
+// GetDag gets a dag by id
+func GetDag(id string) (*DAG, error) {
+	dag := &DAG{}
+	return dag, db.Connection.Where("id = ?", id).First(dag).Error
+}
+
+// ListDags lists the dags sorted by id
+func ListDags() ([]*DAG, error) {
+	dags := []*DAG{}
+	return dags, db.Connection.Order("id").Find(&dags).Error
 }
@@ -10,11 +10,83 @@ import (
 // DagRun describes an instance of a Dag. It can be created by the scheduler or by an external trigger
 type DagRun struct {
 	ID            int
-	DagID         string
-	ExecutionDate time.Time
+	DagID         string    `gorm:"unique_index:idx_dag_run_execution_date"`
+	ExecutionDate time.Time `gorm:"unique_index:idx_dag_run_execution_date"`
 	State         state.State
 	StartDate     time.Time
 	EndDate       time.Time
+	DagHash       string
+}
+
+// GetDagRun gets a dag run by id
+func GetDagRun(id int) (*DagRun, error) {
+	dagRun := &DagRun{}
+	return dagRun, db.Connection.First(dagRun, id).Error
+}
+
+// DagRunExists checks if a dag already has a run for an execution date
+func DagRunExists(dagID string, executionDate time.Time) (bool, error) {
+	count := 0
+	err := db.Connection.Model(&DagRun{}).Where("dag_id = ? and execution_date = ?", dagID, executionDate).Count(&count).Error
+	return count > 0, err
+}
+
+// ListDagRuns lists the latest runs of a dag, newest first
+func ListDagRuns(dagID string, limit int) ([]*DagRun, error) {
+	dagRuns := []*DagRun{}
+	return dagRuns, db.Connection.Where("dag_id = ?", dagID).Order("execution_date desc").Limit(limit).Find(&dagRuns).Error
+}
+
+// DagRunFilter filters and pages the runs of a dag
+type DagRunFilter struct {
+	DagID            string
+	States           []state.State
+	ExecutionDateGTE time.Time
+	ExecutionDateLTE time.Time
+	Limit            int
+	Offset           int
+}
+
+// FindDagRuns finds a page of dag runs, newest first. Also returns the number of dag runs
+// matching the filter
+func FindDagRuns(f DagRunFilter) ([]*DagRun, int, error) {
+	query := db.Connection.Model(&DagRun{}).Where("dag_id = ?", f.DagID)
+	if len(f.States) > 0 {
+		query = query.Where("state in (?)", f.States)
+	}
+	if !f.ExecutionDateGTE.IsZero() {
+		query = query.Where("execution_date >= ?", f.ExecutionDateGTE)
+	}
+	if !f.ExecutionDateLTE.IsZero() {
+		query = query.Where("execution_date <= ?", f.ExecutionDateLTE)
+	}
+	total := 0
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	dagRuns := []*DagRun{}
+	return dagRuns, total, query.Order("execution_date desc").Offset(f.Offset).Limit(f.Limit).Find(&dagRuns).Error
+}
+
+// In copies the dag run with its dates in a time zone for display. Dates are stored in UTC
+func (d *DagRun) In(loc *time.Location) *DagRun {
+	dagRun := *d
+	dagRun.ExecutionDate = d.ExecutionDate.In(loc)
+	dagRun.StartDate = d.StartDate.In(loc)
+	dagRun.EndDate = d.EndDate.In(loc)
+	return &dagRun
+}
+
+// ActiveDagRuns gets dag runs that are running or queued
+func ActiveDagRuns() ([]*DagRun, error) {
+	dagRuns := []*DagRun{}
+	return dagRuns, db.Connection.Where("state in (?)", []state.State{state.Running, state.Queued}).Order("id").Find(&dagRuns).Error
+}
+
+// TaskInstances gets the task instances of a dag run
+func (d *DagRun) TaskInstances() ([]*TaskInstance, error) {
+	taskInstances := []*TaskInstance{}
+	return taskInstances, db.Connection.Where(TaskInstance{DagRunID: d.ID}).Order("id").Find(&taskInstances).Error
 }
 
 func (d *DagRun) Create() error {
@@ -24,8 +96,7 @@ func (d *DagRun) Create() error {
 
 func (d *DagRun) UpdateState(s state.State) error {
 	conn := db.Connection
-	conn.Model(d).Update("state", s)
-	return conn.Error
+	return conn.Model(d).Update("state", s).Error
 }
 
 func (d *DagRun) Finish(s state.State) error {
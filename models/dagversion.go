@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/estenssoros/relay/db"
+)
+
+// DagVersion the serialized structure of a dag, identified by its hash. A dag gets a
+// version each time a scheduler adds it with a changed structure
+type DagVersion struct {
+	ID        int
+	DagID     string `gorm:"unique_index:idx_dag_version"`
+	Hash      string `gorm:"unique_index:idx_dag_version"`
+	Structure string `gorm:"type:text"`
+	CreatedAt time.Time
+}
+
+// SaveDagVersion stores the structure of a dag as the version with its hash. An existing
+// version keeps its creation time and takes the structure, which can differ in what the
+// hash leaves out
+func SaveDagVersion(dagID, hash, structure string) (*DagVersion, error) {
+	version := &DagVersion{}
+	err := db.Connection.Where(DagVersion{DagID: dagID, Hash: hash}).Attrs(DagVersion{
+		CreatedAt: time.Now().UTC(),
+	}).Assign(DagVersion{
+		Structure: structure,
+	}).FirstOrCreate(version).Error
+	return version, err
+}
+
+// GetDagVersion gets a version of a dag by hash
+func GetDagVersion(dagID, hash string) (*DagVersion, error) {
+	version := &DagVersion{}
+	return version, db.Connection.Where("dag_id = ? and hash = ?", dagID, hash).First(version).Error
+}
+
+// ListDagVersions lists the versions of a dag, newest first
+func ListDagVersions(dagID string) ([]*DagVersion, error) {
+	versions := []*DagVersion{}
+	return versions, db.Connection.Where("dag_id = ?", dagID).Order("created_at desc, id desc").Find(&versions).Error
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/estenssoros/dasorm/nulls"
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/state"
+)
+
+// Job a scheduler or worker process. Jobs heartbeat while they are alive so task
+// instances left running by a process that stopped can be found
+type Job struct {
+	ID              int `gorm:"PRIMARY_KEY"`
+	JobType         string
+	State           state.State
+	HostName        string
+	UnixName        string
+	PID             int
+	StartDate       time.Time
+	EndDate         nulls.Time
+	LatestHeartbeat time.Time
+	// LatestLoop when the scheduling loop of a scheduler job last ran, as of its latest
+	// heartbeat. A scheduler can heartbeat while its loop is stuck
+	LatestLoop time.Time
+}
+
+// NewJob creates a running job for this process
+func NewJob(jobType string) (*Job, error) {
+	hostName, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	unixName := ""
+	if u, err := user.Current(); err == nil {
+		unixName = u.Username
+	}
+	now := time.Now().UTC()
+	j := &Job{
+		JobType:         jobType,
+		State:           state.Running,
+		HostName:        hostName,
+		UnixName:        unixName,
+		PID:             os.Getpid(),
+		StartDate:       now,
+		LatestHeartbeat: now,
+		LatestLoop:      now,
+	}
+	return j, db.Connection.Create(j).Error
+}
+
+// GetJob gets a job by id
+func GetJob(id int) (*Job, error) {
+	j := &Job{}
+	return j, db.Connection.First(j, id).Error
+}
+
+// Heartbeat records that the job is still alive
+func (j *Job) Heartbeat() error {
+	return db.Connection.Model(&Job{ID: j.ID}).Update("latest_heartbeat", time.Now().UTC()).Error
+}
+
+// HeartbeatLoop records that the job is still alive and when its scheduling loop last ran
+func (j *Job) HeartbeatLoop(loop time.Time) error {
+	return db.Connection.Model(&Job{ID: j.ID}).Updates(map[string]interface{}{
+		"latest_heartbeat": time.Now().UTC(),
+		"latest_loop":      loop.UTC(),
+	}).Error
+}
+
+// End records that the job stopped
+func (j *Job) End() error {
+	return db.Connection.Model(&Job{ID: j.ID}).Updates(Job{State: state.Success, EndDate: nulls.NewTime(time.Now().UTC())}).Error
+}
+
+// ZombieTaskInstances gets task instances left running by a job that ended or has not
+// heartbeat within timeout
+func ZombieTaskInstances(timeout time.Duration) ([]*TaskInstance, error) {
+	taskInstances := []*TaskInstance{}
+	return taskInstances, db.Connection.
+		Joins("join jobs on jobs.id = task_instances.job_id").
+		Where("task_instances.state = ? and (jobs.state <> ? or jobs.latest_heartbeat < ?)", state.Running, state.Running, time.Now().UTC().Add(-timeout)).
+		Order("task_instances.id").
+		Find(&taskInstances).Error
+}
+
+// LatestJob gets the running job of a type that heartbeat last
+func LatestJob(jobType string) (*Job, error) {
+	j := &Job{}
+	return j, db.Connection.
+		Where("job_type = ? and state = ?", jobType, state.Running).
+		Order("latest_heartbeat desc").
+		First(j).Error
+}
+
+// CountLiveJobs counts the running jobs of a type that heartbeat within timeout
+func CountLiveJobs(jobType string, timeout time.Duration) (int, error) {
+	count := 0
+	return count, db.Connection.Model(&Job{}).
+		Where("job_type = ? and state = ? and latest_heartbeat >= ?", jobType, state.Running, time.Now().UTC().Add(-timeout)).
+		Count(&count).Error
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"github.com/estenssoros/relay/db"
+)
+
+// Lease a named lock held by one holder until it expires. Schedulers sharing a relay
+// database elect a leader by holding the scheduler lease
+type Lease struct {
+	Name      string `gorm:"PRIMARY_KEY"`
+	Holder    string
+	ExpiresAt time.Time
+}
+
+// GetLease gets a lease by name
+func GetLease(name string) (*Lease, error) {
+	lease := &Lease{}
+	return lease, db.Connection.Where("name = ?", name).First(lease).Error
+}
+
+// AcquireLease takes or renews a lease for ttl. Returns false if another holder has a
+// lease that has not expired. Expiry is set and checked on the database clock, so holders
+// on hosts whose clocks disagree still agree on it
+func AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	now, err := db.Now()
+	if err != nil {
+		return false, err
+	}
+	update := db.Connection.Model(&Lease{}).
+		Where("name = ? and (holder = ? or expires_at < ?)", name, holder, now).
+		Updates(map[string]interface{}{"holder": holder, "expires_at": now.Add(ttl)})
+	if update.Error != nil {
+		return false, update.Error
+	}
+	if update.RowsAffected == 1 {
+		return true, nil
+	}
+	count := 0
+	if err := db.Connection.Model(&Lease{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if err := db.Connection.Create(&Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}).Error; err != nil {
+		lease, getErr := GetLease(name)
+		if getErr != nil {
+			return false, err
+		}
+		return lease.Holder == holder, nil // another holder created the lease first
+	}
+	return true, nil
+}
+
+// ReleaseLease gives up a lease so another holder can take it right away
+func ReleaseLease(name, holder string) error {
+	return db.Connection.Model(&Lease{}).
+		Where("name = ? and holder = ?", name, holder).
+		Update("expires_at", time.Time{}).Error
+}
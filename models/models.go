@@ -6,4 +6,11 @@ var Migrations = []interface{}{
 	&DAG{},
 	&DagRun{},
 	&TaskInstance{},
+	&Pool{},
+	&QueuedTask{},
+	&Job{},
+	&Lease{},
+	&TaskTry{},
+	&DagVersion{},
+	&User{},
 }
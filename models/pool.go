@@ -0,0 +1,41 @@
+package models
+
+import (
+	"github.com/estenssoros/relay/db"
+)
+
+// Pool limits the number of task instances that can run at the same time
+// against a shared resource, such as a database, across all running dags
+type Pool struct {
+	ID          int    `gorm:"PRIMARY_KEY"`
+	Pool        string `gorm:"unique_index"`
+	Slots       int
+	Description string
+}
+
+// ListPools lists all pools
+func ListPools() ([]*Pool, error) {
+	pools := []*Pool{}
+	return pools, db.Connection.Order("pool").Find(&pools).Error
+}
+
+// GetPool gets a pool by name
+func GetPool(name string) (*Pool, error) {
+	pool := &Pool{}
+	return pool, db.Connection.Where(Pool{Pool: name}).First(pool).Error
+}
+
+// SetPool creates a pool or updates the slots and description of an existing one
+func SetPool(name string, slots int, description string) (*Pool, error) {
+	pool := &Pool{}
+	err := db.Connection.Where(Pool{Pool: name}).Assign(map[string]interface{}{
+		"slots":       slots,
+		"description": description,
+	}).FirstOrCreate(pool).Error
+	return pool, err
+}
+
+// DeletePool deletes a pool by name
+func DeletePool(name string) error {
+	return db.Connection.Where(Pool{Pool: name}).Delete(Pool{}).Error
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"time"
+
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/state"
+	"github.com/jinzhu/gorm"
+)
+
+// QueuedTask a task instance handed to distributed workers. The scheduler enqueues it,
+// a worker claims it, heartbeats while running it and writes the result back
+type QueuedTask struct {
+	ID             int `gorm:"PRIMARY_KEY"`
+	TaskInstanceID int `gorm:"unique_index"`
+	DagID          string
+	TaskID         string
+	DagRunID       int
+	PriorityWeight int
+	Worker         string
+	JobID          int
+	ClaimedAt      time.Time
+	HeartbeatAt    time.Time
+	State          state.State
+	Message        string
+	// Traceparent trace context the worker runs the task in
+	Traceparent string
+}
+
+// EnqueueTask adds a task instance to the queue, replacing any earlier entry for it. The
+// traceparent is passed on to the worker and may be empty
+func EnqueueTask(t *TaskInstance, dagID, traceparent string) (*QueuedTask, error) {
+	if err := db.Connection.Where(QueuedTask{TaskInstanceID: t.ID}).Delete(QueuedTask{}).Error; err != nil {
+		return nil, err
+	}
+	q := &QueuedTask{
+		TaskInstanceID: t.ID,
+		DagID:          dagID,
+		TaskID:         t.TaskID,
+		DagRunID:       t.DagRunID,
+		PriorityWeight: t.PriorityWeight,
+		State:          state.Queued,
+		Traceparent:    traceparent,
+	}
+	return q, db.Connection.Create(q).Error
+}
+
+// ClaimQueuedTask claims the unclaimed task with the highest priority weight for a worker.
+// Rows are locked with skip locked on MySQL and Postgres so workers never wait on each
+// other; the claim itself only succeeds if the row is still unclaimed. Returns nil if
+// there is nothing to claim
+func ClaimQueuedTask(worker string, jobID int) (*QueuedTask, error) {
+	tx := db.Connection.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer tx.RollbackUnlessCommitted()
+
+	query := tx.Where("worker = ?", "").Order("priority_weight desc, id")
+	switch db.Connection.Dialect().GetName() {
+	case "mysql", "postgres":
+		query = query.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED")
+	}
+	q := &QueuedTask{}
+	if err := query.First(q).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now().UTC()
+	claim := tx.Model(q).Where("worker = ?", "").Updates(map[string]interface{}{
+		"worker":       worker,
+		"job_id":       jobID,
+		"claimed_at":   now,
+		"heartbeat_at": now,
+		"state":        state.Running,
+	})
+	if claim.Error != nil {
+		return nil, claim.Error
+	}
+	if claim.RowsAffected == 0 { // claimed by another worker
+		return nil, nil
+	}
+	return q, tx.Commit().Error
+}
+
+// Reload reads the queued task from the database
+func (q *QueuedTask) Reload() error {
+	return db.Connection.First(q, q.ID).Error
+}
+
+// Heartbeat records that the worker is still running the task. Returns false if the
+// scheduler took the task off the queue, which cancels it
+func (q *QueuedTask) Heartbeat() (bool, error) {
+	beat := db.Connection.Model(q).Where("worker = ?", q.Worker).Update("heartbeat_at", time.Now().UTC())
+	return beat.RowsAffected == 1, beat.Error
+}
+
+// Finish writes the result of the task back for the scheduler
+func (q *QueuedTask) Finish(s state.State, message string) error {
+	return db.Connection.Model(q).Updates(map[string]interface{}{
+		"state":   s,
+		"message": message,
+	}).Error
+}
+
+// Release gives the task back to the queue so another worker can claim it
+func (q *QueuedTask) Release() error {
+	return db.Connection.Model(q).Updates(map[string]interface{}{
+		"worker": "",
+		"state":  state.Queued,
+	}).Error
+}
+
+// Delete takes the task off the queue. A worker running the task stops it on its next heartbeat
+func (q *QueuedTask) Delete() error {
+	return db.Connection.Delete(q).Error
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/estenssoros/dasorm/nulls"
@@ -23,7 +24,10 @@ type TaskInstance struct {
 	MaxTries       int
 	HostName       string
 	UnixName       string
+	PID            int
+	JobID          int
 	PriorityWeight int
+	Pool           string
 	Operator       string
 	Message        string
 }
@@ -40,6 +44,7 @@ func (t *TaskInstance) Update() error {
 func (t *TaskInstance) Start() error {
 	t.State = state.Running
 	t.StartDate = time.Now().UTC()
+	t.TryNumber++
 	conn := db.Connection
 	return conn.Save(t).Error
 }
@@ -49,5 +54,93 @@ func (t *TaskInstance) Stop() error {
 	t.Duration = now.Sub(t.StartDate).Seconds()
 	t.EndDate = nulls.NewTime(now)
 	conn := db.Connection
+	if err := conn.Save(t).Error; err != nil {
+		return err
+	}
+	return t.recordTry()
+}
+
+// Fail fails a running task instance outside of a worker, recording the try
+func (t *TaskInstance) Fail(message string) error {
+	t.State = state.Failed
+	t.Message = message
+	return t.Stop()
+}
+
+// GetTaskInstance gets the task instance of a task in a dag run
+func GetTaskInstance(dagRunID int, taskID string) (*TaskInstance, error) {
+	ti := &TaskInstance{}
+	return ti, db.Connection.Where("dag_run_id = ? and task_id = ?", dagRunID, taskID).First(ti).Error
+}
+
+// TaskInstanceFilter filters and pages the task instances of a dag run
+type TaskInstanceFilter struct {
+	DagRunID     int
+	States       []state.State
+	StartDateGTE time.Time
+	StartDateLTE time.Time
+	Limit        int
+	Offset       int
+}
+
+// FindTaskInstances finds a page of task instances. Also returns the number of task
+// instances matching the filter
+func FindTaskInstances(f TaskInstanceFilter) ([]*TaskInstance, int, error) {
+	query := db.Connection.Model(&TaskInstance{}).Where("dag_run_id = ?", f.DagRunID)
+	if len(f.States) > 0 {
+		query = query.Where("state in (?)", f.States)
+	}
+	if !f.StartDateGTE.IsZero() {
+		query = query.Where("start_date >= ?", f.StartDateGTE)
+	}
+	if !f.StartDateLTE.IsZero() {
+		query = query.Where("start_date <= ?", f.StartDateLTE)
+	}
+	total := 0
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	taskInstances := []*TaskInstance{}
+	return taskInstances, total, query.Order("id").Offset(f.Offset).Limit(f.Limit).Find(&taskInstances).Error
+}
+
+// In copies the task instance with its dates in a time zone for display
+func (t *TaskInstance) In(loc *time.Location) *TaskInstance {
+	ti := *t
+	ti.StartDate = t.StartDate.In(loc)
+	ti.EndDate = inZone(t.EndDate, loc)
+	return &ti
+}
+
+// SetJob stamps the task instance with the job running it
+func (t *TaskInstance) SetJob(j *Job) {
+	t.JobID = j.ID
+	t.HostName = j.HostName
+	t.UnixName = j.UnixName
+	t.PID = j.PID
+}
+
+// Clear resets a task instance so it runs again
+func (t *TaskInstance) Clear() error {
+	t.State = state.None
+	t.EndDate = nulls.Time{}
+	t.Duration = 0
+	t.Message = ""
+	conn := db.Connection
+	return conn.Save(t).Error
+}
+
+// Mark sets the state of a task instance by hand, leaving an audit note in the message
+func (t *TaskInstance) Mark(s state.State, note string) error {
+	now := time.Now().UTC()
+	t.State = s
+	t.Message = fmt.Sprintf("marked %s at %s", s, now.Format(time.RFC3339))
+	if note != "" {
+		t.Message += ": " + note
+	}
+	if !t.EndDate.Valid {
+		t.EndDate = nulls.NewTime(now)
+	}
+	conn := db.Connection
 	return conn.Save(t).Error
 }
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/estenssoros/dasorm/nulls"
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/state"
+)
+
+// TaskTry records a finished try of a task instance. Task instances keep only their latest
+// try, so tries are the history of every attempt
+type TaskTry struct {
+	ID             int `gorm:"PRIMARY_KEY"`
+	TaskInstanceID int `gorm:"index"`
+	TryNumber      int
+	StartDate      time.Time
+	EndDate        nulls.Time
+	Duration       float64
+	State          state.State
+	HostName       string
+	PID            int
+	JobID          int
+	Message        string
+}
+
+// recordTry stores the current try of a task instance
+func (t *TaskInstance) recordTry() error {
+	return db.Connection.Create(&TaskTry{
+		TaskInstanceID: t.ID,
+		TryNumber:      t.TryNumber,
+		StartDate:      t.StartDate,
+		EndDate:        t.EndDate,
+		Duration:       t.Duration,
+		State:          t.State,
+		HostName:       t.HostName,
+		PID:            t.PID,
+		JobID:          t.JobID,
+		Message:        t.Message,
+	}).Error
+}
+
+// Tries gets the finished tries of a task instance, first try first
+func (t *TaskInstance) Tries() ([]*TaskTry, error) {
+	tries := []*TaskTry{}
+	return tries, db.Connection.Where("task_instance_id = ?", t.ID).Order("id").Find(&tries).Error
+}
+
+// In copies the try with its dates in a time zone for display
+func (t *TaskTry) In(loc *time.Location) *TaskTry {
+	try := *t
+	try.StartDate = t.StartDate.In(loc)
+	try.EndDate = inZone(t.EndDate, loc)
+	return &try
+}
+
+// inZone converts a nullable time to a time zone
+func inZone(t nulls.Time, loc *time.Location) nulls.Time {
+	if !t.Valid {
+		return t
+	}
+	return nulls.NewTime(t.Time.In(loc))
+}
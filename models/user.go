@@ -0,0 +1,118 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/estenssoros/relay/db"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role what a user may do in the webserver
+type Role string
+
+const (
+	// RoleNone can not see a dag
+	RoleNone Role = ""
+	// RoleViewer can see dags, runs, task instances and logs
+	RoleViewer Role = "viewer"
+	// RoleOperator can also trigger, pause, clear, mark, cancel and kill
+	RoleOperator Role = "operator"
+	// RoleAdmin can also manage pools and stop the scheduler, and can act on every dag
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{RoleNone: 0, RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// Valid checks if the role is one users can have
+func (r Role) Valid() bool {
+	return r == RoleViewer || r == RoleOperator || r == RoleAdmin
+}
+
+// Allows checks if the role may do what the required role may do
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+// User a user of the webserver. It logs in with its password for a session or sends its
+// api token as a bearer token. Only hashes of both are stored
+type User struct {
+	ID           int
+	Username     string `gorm:"unique_index"`
+	Role         Role
+	PasswordHash string `json:"-"`
+	TokenHash    string `json:"-" gorm:"index"`
+	CreatedAt    time.Time
+}
+
+// CreateUser creates a user with a new api token, which is returned because it can not be
+// read back
+func CreateUser(username, password string, role Role) (*User, string, error) {
+	if username == "" || password == "" {
+		return nil, "", errors.New("username and password required")
+	}
+	if !role.Valid() {
+		return nil, "", errors.Errorf("unknown role %q, must be viewer, operator or admin", role)
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "hash password")
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", errors.Wrap(err, "generate token")
+	}
+	token := hex.EncodeToString(b)
+	user := &User{
+		Username:     username,
+		Role:         role,
+		PasswordHash: string(passwordHash),
+		TokenHash:    hashToken(token),
+		CreatedAt:    time.Now().UTC(),
+	}
+	return user, token, db.Connection.Create(user).Error
+}
+
+// hashToken hashes an api token for storage. Tokens are random so sha256 is enough
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckPassword checks a password against the user's password hash
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// GetUser gets a user by username
+func GetUser(username string) (*User, error) {
+	user := &User{}
+	return user, db.Connection.Where("username = ?", username).First(user).Error
+}
+
+// GetUserByToken gets the user an api token belongs to
+func GetUserByToken(token string) (*User, error) {
+	user := &User{}
+	return user, db.Connection.Where("token_hash = ?", hashToken(token)).First(user).Error
+}
+
+// ListUsers lists all users
+func ListUsers() ([]*User, error) {
+	users := []*User{}
+	return users, db.Connection.Order("username").Find(&users).Error
+}
+
+// DeleteUser deletes a user by username
+func DeleteUser(username string) error {
+	res := db.Connection.Where("username = ?", username).Delete(User{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.Errorf("user %s not found", username)
+	}
+	return nil
+}
@@ -1,11 +1,10 @@
 package relay
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 
-	"github.com/estenssoros/relay/models"
-	"github.com/estenssoros/relay/state"
 	"github.com/pkg/errors"
 )
 
@@ -15,13 +14,14 @@ type MySQLOperator struct {
 	DAG               *DAG `json:"-"` // avoid recursion
 	ConnectionID      string
 	Retries           int
+	Pool              string
+	PriorityWeight    int
+	WeightRule        WeightRule
 	Message           string
 	SQLCommand        string
 	SQLFileLoc        string
 	upstreamTaskIDs   []string
 	downstreamTaskIDs []string
-	State             state.State
-	model             *models.TaskInstance
 }
 
 func (o *MySQLOperator) String() string { return o.TaskID }
@@ -34,9 +34,9 @@ func (o *MySQLOperator) FormattedID() string { return fmt.Sprintf("[TASK] %s", o
 
 func (o *MySQLOperator) hasUpstream() bool { return len(o.upstreamTaskIDs) > 0 }
 
-func (o *MySQLOperator) downstreamList() []TaskInterface { return downstreamList(o) }
+func (o *MySQLOperator) downstreamList() []TaskInterface { return taskList(o.DAG, o.downstreamTaskIDs) }
 
-func (o *MySQLOperator) upstreamList() []TaskInterface { return upstreamList(o) }
+func (o *MySQLOperator) upstreamList() []TaskInterface { return taskList(o.DAG, o.upstreamTaskIDs) }
 
 // GetDag returns the dag for an operator
 func (o *MySQLOperator) GetDag() *DAG { return o.DAG }
@@ -55,11 +55,11 @@ func (o *MySQLOperator) addUpstreamTask(taskID string) {
 	o.upstreamTaskIDs = append(o.upstreamTaskIDs, taskID)
 }
 
-// SetState sets the state on an operator
-func (o *MySQLOperator) SetState(s state.State) { o.State = s }
+// SetUpstream creates relationships between tasks
+func (o *MySQLOperator) SetUpstream(task TaskInterface) error { return setRelatives(o, task, true) }
 
-// GetState gets the state from an operator
-func (o *MySQLOperator) GetState() state.State { return o.State }
+// SetDownStream creates relationships between tasks
+func (o *MySQLOperator) SetDownStream(task TaskInterface) error { return setRelatives(o, task, false) }
 
 // IsRoot checks to see if an operator has upstream tasks
 func (o *MySQLOperator) IsRoot() bool { return !o.hasUpstream() }
@@ -75,7 +75,7 @@ func (o *MySQLOperator) check() error {
 }
 
 // Run run the bash operator
-func (o *MySQLOperator) Run() error {
+func (o *MySQLOperator) Run(ctx context.Context) error {
 	if err := o.check(); err != nil {
 		return errors.Wrap(err, "mysql operator check")
 	}
@@ -103,10 +103,19 @@ func (o *MySQLOperator) Run() error {
 // OperatorType returns the type of the operator
 func (o *MySQLOperator) OperatorType() string { return `mysql` }
 
-// SetModel sets a model to the operator
-func (o *MySQLOperator) SetModel(m *models.TaskInstance) {
-	o.model = m
-}
+// GetPool gets the pool the operator runs in
+func (o *MySQLOperator) GetPool() string { return o.Pool }
+
+// GetPriorityWeight gets the priority weight of the operator
+func (o *MySQLOperator) GetPriorityWeight() int { return o.PriorityWeight }
+
+// GetWeightRule gets the weight rule of the operator
+func (o *MySQLOperator) GetWeightRule() WeightRule { return o.WeightRule }
 
-// GetModel gets the model from the operator
-func (o *MySQLOperator) GetModel() *models.TaskInstance { return o.model }
+// GetRetries gets the number of times the operator is retried
+func (o *MySQLOperator) GetRetries() int { return o.Retries }
+
+// serializeParams the key parameters of the operator in the serialized dag
+func (o *MySQLOperator) serializeParams() map[string]interface{} {
+	return map[string]interface{}{"connectionID": o.ConnectionID, "sqlCommand": o.SQLCommand, "sqlFileLoc": o.SQLFileLoc}
+}
@@ -0,0 +1,375 @@
+package relay
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/leaanthony/mewn/lib"
+	"github.com/pkg/errors"
+)
+
+// size of graph nodes and the space between them in pixels
+var (
+	graphNodeWidth  = 160
+	graphNodeHeight = 32
+	graphGapX       = 60
+	graphGapY       = 20
+	graphPadding    = 10
+)
+
+// defaultGridRuns number of recent runs in the grid of the dag page
+var defaultGridRuns = 25
+
+// graphNode a task drawn in the dag graph, colored by its state in the latest run
+type graphNode struct {
+	TaskID string
+	State  state.State
+	X, Y   int
+	CX, CY int
+}
+
+// graphEdge a dependency drawn in the dag graph
+type graphEdge struct {
+	X1, Y1, X2, Y2 int
+}
+
+// dagGraph the task graph of a dag laid out in layers from the root tasks
+type dagGraph struct {
+	Width, Height         int
+	NodeWidth, NodeHeight int
+	Nodes                 []*graphNode
+	Edges                 []*graphEdge
+}
+
+// dagOrientation the orientation the dag graph is drawn in: LR, RL, TB or BT
+func dagOrientation(dag *DAG) string {
+	orientation := strings.ToUpper(dag.Orientation)
+	if orientation == "" {
+		orientation = strings.ToUpper(config.DefaultConfig.Webserver.DagOrientation)
+	}
+	switch orientation {
+	case "LR", "RL", "TB", "BT":
+		return orientation
+	}
+	return "LR"
+}
+
+// taskDepths the depth of each task, its longest path from a root task
+func taskDepths(dag *DAG) map[string]int {
+	depths := map[string]int{}
+	var depth func(t TaskInterface) int
+	depth = func(t TaskInterface) int {
+		if d, ok := depths[t.GetID()]; ok {
+			return d
+		}
+		d := 0
+		for _, up := range t.upstreamList() {
+			if upDepth := depth(up) + 1; upDepth > d {
+				d = upDepth
+			}
+		}
+		depths[t.GetID()] = d
+		return d
+	}
+	for _, t := range dag.tasks {
+		depth(t)
+	}
+	return depths
+}
+
+// graphOrder task ids ordered by depth, then id
+func graphOrder(depths map[string]int) []string {
+	taskIDs := []string{}
+	for taskID := range depths {
+		taskIDs = append(taskIDs, taskID)
+	}
+	sort.Slice(taskIDs, func(i, j int) bool {
+		if depths[taskIDs[i]] != depths[taskIDs[j]] {
+			return depths[taskIDs[i]] < depths[taskIDs[j]]
+		}
+		return taskIDs[i] < taskIDs[j]
+	})
+	return taskIDs
+}
+
+// layoutGraph lays out the tasks of a dag in layers by depth, in an orientation
+func layoutGraph(dag *DAG, orientation string, states map[string]state.State) *dagGraph {
+	depths := taskDepths(dag)
+	layers, maxDepth, maxWidth := map[int]int{}, 0, 0
+	nodes := map[string]*graphNode{}
+	graph := &dagGraph{NodeWidth: graphNodeWidth, NodeHeight: graphNodeHeight}
+	for _, taskID := range graphOrder(depths) {
+		depth := depths[taskID]
+		index := layers[depth]
+		layers[depth]++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		if layers[depth] > maxWidth {
+			maxWidth = layers[depth]
+		}
+		if orientation == "RL" || orientation == "BT" {
+			depth = -depth // mirrored below
+		}
+		node := &graphNode{TaskID: taskID, State: states[taskID]}
+		if orientation == "LR" || orientation == "RL" {
+			node.X, node.Y = depth*(graphNodeWidth+graphGapX), index*(graphNodeHeight+graphGapY)
+		} else {
+			node.X, node.Y = index*(graphNodeWidth+graphGapX), depth*(graphNodeHeight+graphGapY)
+		}
+		nodes[taskID] = node
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	layersX, layersY := maxDepth+1, maxWidth
+	if orientation == "TB" || orientation == "BT" {
+		layersX, layersY = maxWidth, maxDepth+1
+	}
+	for _, node := range graph.Nodes {
+		if orientation == "RL" {
+			node.X += maxDepth * (graphNodeWidth + graphGapX)
+		}
+		if orientation == "BT" {
+			node.Y += maxDepth * (graphNodeHeight + graphGapY)
+		}
+		node.X += graphPadding
+		node.Y += graphPadding
+		node.CX, node.CY = node.X+graphNodeWidth/2, node.Y+graphNodeHeight/2
+	}
+	graph.Width = layersX*(graphNodeWidth+graphGapX) - graphGapX + 2*graphPadding
+	graph.Height = layersY*(graphNodeHeight+graphGapY) - graphGapY + 2*graphPadding
+	for _, node := range graph.Nodes {
+		task := dag.tasks[node.TaskID]
+		for _, down := range task.downstreamList() {
+			graph.Edges = append(graph.Edges, edgeBetween(node, nodes[down.GetID()], orientation))
+		}
+	}
+	return graph
+}
+
+// edgeBetween connects the facing sides of an upstream and downstream node
+func edgeBetween(up, down *graphNode, orientation string) *graphEdge {
+	midX, midY := graphNodeWidth/2, graphNodeHeight/2
+	switch orientation {
+	case "RL":
+		return &graphEdge{up.X, up.Y + midY, down.X + graphNodeWidth, down.Y + midY}
+	case "TB":
+		return &graphEdge{up.X + midX, up.Y + graphNodeHeight, down.X + midX, down.Y}
+	case "BT":
+		return &graphEdge{up.X + midX, up.Y, down.X + midX, down.Y + graphNodeHeight}
+	}
+	return &graphEdge{up.X + graphNodeWidth, up.Y + midY, down.X, down.Y + midY}
+}
+
+// gridCell a task instance in the grid of recent runs
+type gridCell struct {
+	DagRunID int
+	TaskID   string
+	State    state.State
+	Try      int
+	LogURL   string
+}
+
+// gridRow the task instances of a task in the grid of recent runs, oldest run first
+type gridRow struct {
+	TaskID string
+	Cells  []*gridCell
+}
+
+// dagPage data of the dag detail page
+type dagPage struct {
+	Dag         *DagDetail
+	Orientation string
+	Graph       *dagGraph
+	Runs        []*models.DagRun
+	Rows        []*gridRow
+	// LatestRunID the run the graph shows the states of
+	LatestRunID int
+	// NoTasks if the tasks of the dag are not known, as in a standalone webserver before
+	// a scheduler has stored the dag's structure
+	NoTasks bool
+}
+
+// taskLogURL url of the log page of a task instance
+func taskLogURL(dagID string, dagRunID int, taskID string) string {
+	return fmt.Sprintf("/dags/%s/runs/%d/tasks/%s/log", url.PathEscape(dagID), dagRunID, url.PathEscape(taskID))
+}
+
+// newDagPage loads the graph and the grid of the last n runs of a dag
+func newDagPage(dag *DAG, n int) (*dagPage, error) {
+	dagRuns, _, err := models.FindDagRuns(models.DagRunFilter{DagID: dag.ID, Limit: n})
+	if err != nil {
+		return nil, errors.Wrap(err, "find dag runs")
+	}
+	page := &dagPage{Dag: dagDetail(dag), Orientation: dagOrientation(dag), NoTasks: len(dag.tasks) == 0}
+	order := graphOrder(taskDepths(dag))
+	known := map[string]bool{}
+	for _, taskID := range order {
+		known[taskID] = true
+	}
+	runTasks := make([]map[string]*models.TaskInstance, len(dagRuns))
+	extra := []string{}
+	for i, dagRun := range dagRuns {
+		taskInstances, err := dagRun.TaskInstances()
+		if err != nil {
+			return nil, errors.Wrapf(err, "task instances of run %d", dagRun.ID)
+		}
+		runTasks[i] = map[string]*models.TaskInstance{}
+		for _, ti := range taskInstances {
+			runTasks[i][ti.TaskID] = ti
+			if !known[ti.TaskID] { // removed from the dag or not loaded by a standalone webserver
+				known[ti.TaskID] = true
+				extra = append(extra, ti.TaskID)
+			}
+		}
+	}
+	sort.Strings(extra)
+	order = append(order, extra...)
+	cells := map[string][]*gridCell{}
+	latest := map[string]state.State{}
+	for i := len(dagRuns) - 1; i >= 0; i-- {
+		dagRun := dagRuns[i]
+		for _, taskID := range order {
+			cell := &gridCell{DagRunID: dagRun.ID, TaskID: taskID, State: state.None}
+			if ti, ok := runTasks[i][taskID]; ok {
+				cell.State, cell.Try, cell.LogURL = ti.State, ti.TryNumber, taskLogURL(dag.ID, dagRun.ID, taskID)
+			}
+			cells[taskID] = append(cells[taskID], cell)
+			latest[taskID] = cell.State
+		}
+		page.Runs = append(page.Runs, dagRun.In(dag.Location()))
+		page.LatestRunID = dagRun.ID
+	}
+	for _, taskID := range order {
+		page.Rows = append(page.Rows, &gridRow{TaskID: taskID, Cells: cells[taskID]})
+	}
+	page.Graph = layoutGraph(dag, page.Orientation, latest)
+	return page, nil
+}
+
+// taskLogPage data of the task log page
+type taskLogPage struct {
+	DagID        string
+	DagRun       *models.DagRun
+	TaskInstance *models.TaskInstance
+	Tries        []int
+	Try          int
+	Log          string
+	Found        bool
+	// Live if the try is running and its log is tailed
+	Live         bool
+	ClearPattern string
+}
+
+// Pages applies the html pages to echo. Pages are rendered from the embedded templates and
+// need the viewer role on their dag. Static files under /public are served to anyone
+func (w *Webserver) Pages(e *echo.Echo, templates *lib.FileGroup) {
+	e.Renderer = &Template{templates: templates}
+	// static files, including the vendored bootstrap and vue, come from the embedded templates
+	e.GET("/public/*", func(c echo.Context) error {
+		fileName := c.Param("*")
+		if strings.Contains(fileName, "..") {
+			return echo.ErrNotFound
+		}
+		file := templates.Bytes(fileName)
+		if len(file) == 0 {
+			return echo.ErrNotFound
+		}
+		fileContentType := mime.TypeByExtension(filepath.Ext(fileName))
+		if fileContentType == "" {
+			fileContentType = http.DetectContentType(file)
+		}
+		return c.Blob(http.StatusOK, fileContentType, file)
+	})
+	e.GET("/login", func(c echo.Context) error {
+		return c.Render(http.StatusOK, "login.html", map[string]interface{}{
+			"Next":   c.QueryParam("next"),
+			"Failed": c.QueryParam("failed") != "",
+		})
+	})
+	e.GET("/dags/:id", func(c echo.Context) error {
+		dag, err := w.lookupDag(c)
+		if err != nil {
+			return err
+		}
+		n := defaultGridRuns
+		if r := c.QueryParam("runs"); r != "" {
+			if n, err = strconv.Atoi(r); err != nil || n <= 0 || n > maxPageLimit {
+				return echo.NewHTTPError(http.StatusBadRequest, "runs must be between 1 and "+strconv.Itoa(maxPageLimit))
+			}
+		}
+		page, err := newDagPage(dag, n)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "dag.html", page)
+	}, w.requireRole(models.RoleViewer))
+	e.GET("/dags/:id/runs/:run/tasks/:task/log", func(c echo.Context) error {
+		dag, dagRun, err := w.lookupDagRunModel(c)
+		if err != nil {
+			return err
+		}
+		ti, err := models.GetTaskInstance(dagRun.ID, c.Param("task"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "task instance not found")
+		}
+		page := &taskLogPage{
+			DagID:        dag.ID,
+			DagRun:       dagRun.In(dag.Location()),
+			TaskInstance: ti.In(dag.Location()),
+			Try:          ti.TryNumber,
+			ClearPattern: "^" + regexp.QuoteMeta(ti.TaskID) + "$",
+		}
+		for try := 1; try <= ti.TryNumber; try++ {
+			page.Tries = append(page.Tries, try)
+		}
+		if t := c.QueryParam("try"); t != "" {
+			if page.Try, err = strconv.Atoi(t); err != nil || page.Try < 1 || page.Try > ti.TryNumber {
+				return echo.NewHTTPError(http.StatusBadRequest, "try must be between 1 and "+strconv.Itoa(ti.TryNumber))
+			}
+		}
+		if page.Try > 0 {
+			if b, err := readTaskLog(dag.ID, ti.TaskID, dagRun.ID, page.Try); err == nil {
+				page.Log, page.Found = string(b), true
+			}
+			page.Live = page.Try == ti.TryNumber && ti.State == state.Running
+		}
+		return c.Render(http.StatusOK, "log.html", page)
+	}, w.requireRole(models.RoleViewer))
+}
+
+// templateFuncs functions available to page templates
+var templateFuncs = template.FuncMap{
+	"formatDate": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+}
+
+// Template holdes templates
+type Template struct {
+	templates *lib.FileGroup
+}
+
+// Render implements the echo render function
+func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	ts := t.templates.String(name)
+	if ts == "" {
+		return errors.Errorf("missing template: %s", name)
+	}
+	temp, err := template.New(name).Funcs(templateFuncs).Parse(ts)
+	if err != nil {
+		return errors.Wrapf(err, "parse template: %s", name)
+	}
+	return temp.ExecuteTemplate(w, name, data)
+}
@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/leaanthony/mewn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayoutGraph(t *testing.T) {
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.CrossDownstream(tasks[:1], tasks[1:]))
+
+	graph := layoutGraph(dag, "LR", nil)
+	assert.Len(t, graph.Nodes, 3)
+	assert.Len(t, graph.Edges, 2)
+	assert.True(t, graph.Nodes[0].X < graph.Nodes[1].X, "root left of its downstream tasks")
+	assert.Equal(t, graph.Nodes[1].X, graph.Nodes[2].X)
+
+	graph = layoutGraph(dag, "BT", nil)
+	assert.True(t, graph.Nodes[0].Y > graph.Nodes[1].Y, "root below its downstream tasks")
+	assert.Equal(t, graph.Nodes[1].Y, graph.Nodes[2].Y)
+	for _, edge := range graph.Edges {
+		assert.True(t, edge.Y1 > edge.Y2, "edges point up")
+	}
+}
+
+func TestDagPages(t *testing.T) {
+	migrate(t)
+	logFolder := config.DefaultConfig.Core.BaseLogFolder
+	config.DefaultConfig.Core.BaseLogFolder = filepath.Join(os.TempDir(), fmt.Sprintf("relay-logs-%d", time.Now().UnixNano()))
+	defer func() {
+		os.RemoveAll(config.DefaultConfig.Core.BaseLogFolder)
+		config.DefaultConfig.Core.BaseLogFolder = logFolder
+	}()
+
+	dag, tasks := newTestDag(t, "t1", "t2")
+	dag.ID = "pages-" + time.Now().Format(time.RFC3339Nano)
+	assert.Nil(t, dag.Chain(tasks...))
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	ti := &models.TaskInstance{TaskID: "t1", DagRunID: dagRun.ID, TryNumber: 1, State: state.Success}
+	assert.Nil(t, ti.Create())
+	assert.Nil(t, runLogged(context.Background(), &BashOperator{TaskID: "t1", DAG: dag, BashCommand: "echo hello from t1"}, dagRun.ID, 1))
+
+	e := echo.New()
+	w := NewWebserver(NewDagBag(dag))
+	w.Routes(e)
+	w.Pages(e, mewn.Group("./templates"))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/dags/" + dag.ID)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "<svg")
+	assert.Contains(t, rec.Body.String(), "state-success")
+	logURL := taskLogURL(dag.ID, dagRun.ID, "t1")
+	assert.Contains(t, rec.Body.String(), logURL)
+
+	rec = get(logURL)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "hello from t1")
+	assert.Equal(t, http.StatusBadRequest, get(logURL+"?try=2").Code)
+	assert.Equal(t, http.StatusNotFound, get(taskLogURL(dag.ID, dagRun.ID, "t2")).Code)
+	assert.Equal(t, http.StatusNotFound, get("/dags/missing").Code)
+
+	rec = get("/public/vendor/bootstrap/css/bootstrap.min.css")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/css")
+	assert.Equal(t, http.StatusOK, get("/public/vendor/vue/vue.min.js").Code)
+	assert.Equal(t, http.StatusNotFound, get("/public/missing.js").Code)
+	assert.Equal(t, http.StatusNotFound, get("/public/../pages.go").Code)
+}
+
+func TestStandaloneWebserver(t *testing.T) {
+	migrate(t)
+	dag, tasks := newTestDag(t, "t1", "t2")
+	dag.ID = "standalone-" + time.Now().Format(time.RFC3339Nano)
+	dag.Timezone, dag.location = "America/Chicago", nil
+	assert.Nil(t, dag.Chain(tasks...))
+	assert.Nil(t, dag.getOrCreateDagModel())
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	ti := &models.TaskInstance{TaskID: "t2", DagRunID: dagRun.ID, TryNumber: 1, State: state.Failed}
+	assert.Nil(t, ti.Create())
+
+	broken := &models.DAG{ID: dag.ID + "-broken", ScheduleInterval: "@daily", Hash: "missing"}
+	assert.Nil(t, db.Connection.Create(broken).Error)
+
+	dags, err := LoadDagBag()
+	assert.Nil(t, err)
+	loaded, ok := dags.Get(dag.ID)
+	assert.True(t, ok)
+	assert.Equal(t, "America/Chicago", loaded.Location().String())
+	loaded, ok = dags.Get(broken.ID)
+	assert.True(t, ok, "dags whose version does not load are listed without tasks")
+	assert.Empty(t, loaded.tasks)
+
+	e := echo.New()
+	w := NewWebserver(dags)
+	w.Routes(e)
+	w.Pages(e, mewn.Group("./templates"))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dags/"+dag.ID, nil))
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), taskLogURL(dag.ID, dagRun.ID, "t2"), "tasks from task instances")
+	assert.Contains(t, rec.Body.String(), "tasks of this dag are not known yet")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/dags/"+dag.ID+"/runs", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -0,0 +1,51 @@
+package relay
+
+var (
+	// WeightRuleDownstream task weight is its own weight plus the weights of all downstream tasks
+	WeightRuleDownstream WeightRule = "downstream"
+	// WeightRuleUpstream task weight is its own weight plus the weights of all upstream tasks
+	WeightRuleUpstream WeightRule = "upstream"
+	// WeightRuleAbsolute task weight is only its own weight
+	WeightRuleAbsolute WeightRule = "absolute"
+)
+
+// WeightRule determines how the effective priority weight of a task is calculated
+type WeightRule string
+
+// ownWeight the priority weight set on a task. Defaults to 1
+func ownWeight(task TaskInterface) int {
+	if weight := task.GetPriorityWeight(); weight != 0 {
+		return weight
+	}
+	return 1
+}
+
+// relatives collects every task reachable by following next
+func relatives(task TaskInterface, next func(TaskInterface) []TaskInterface, seen map[string]TaskInterface) {
+	for _, t := range next(task) {
+		if _, ok := seen[t.GetID()]; ok {
+			continue
+		}
+		seen[t.GetID()] = t
+		relatives(t, next, seen)
+	}
+}
+
+// priorityWeight calculates the effective priority weight of a task from its weight rule.
+// Higher weights are handed to workers first
+func priorityWeight(task TaskInterface) int {
+	seen := map[string]TaskInterface{}
+	switch task.GetWeightRule() {
+	case WeightRuleAbsolute:
+		return ownWeight(task)
+	case WeightRuleUpstream:
+		relatives(task, TaskInterface.upstreamList, seen)
+	default:
+		relatives(task, TaskInterface.downstreamList, seen)
+	}
+	weight := ownWeight(task)
+	for _, t := range seen {
+		weight += ownWeight(t)
+	}
+	return weight
+}
@@ -0,0 +1,207 @@
+package relay
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// poolRefreshInterval how often pool slots are reloaded from the database
+var poolRefreshInterval = 5 * time.Second
+
+// queuedTask a task instance waiting in the task queue along with the eval
+// queue of the task runner it belongs to
+type queuedTask struct {
+	ti        *TaskInstance
+	evalQueue chan<- *TaskInstance
+	weight    int
+	seq       int
+	err       error
+}
+
+// TaskQueue prioritized queue of tasks shared by every running dag. Tasks are
+// handed to workers by priority weight as long as their dag is under its
+// concurrency and their pool has an open slot
+type TaskQueue struct {
+	mu          sync.Mutex
+	items       []*queuedTask
+	seq         int
+	changed     chan struct{}
+	running     map[string]int
+	dagRunning  map[string]int
+	poolSlots   map[string]int
+	poolsLoaded time.Time
+}
+
+// NewTaskQueue creates a new task queue
+func NewTaskQueue() *TaskQueue {
+	return &TaskQueue{
+		items:      []*queuedTask{},
+		changed:    make(chan struct{}),
+		running:    map[string]int{},
+		dagRunning: map[string]int{},
+	}
+}
+
+// broadcast wakes every worker waiting on the queue. must hold the lock
+func (q *TaskQueue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Push adds a task instance to the queue. The task instance is sent back to evalQueue once a worker has run it
+func (q *TaskQueue) Push(ti *TaskInstance, evalQueue chan<- *TaskInstance) {
+	weight := priorityWeight(ti.Task)
+	if ti.Model != nil {
+		ti.Model.PriorityWeight = weight
+		ti.Model.Pool = ti.Task.GetPool()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	q.items = append(q.items, &queuedTask{
+		ti:        ti,
+		evalQueue: evalQueue,
+		weight:    weight,
+		seq:       q.seq,
+	})
+	q.broadcast()
+}
+
+// Remove takes a task instance off the queue. Returns false if it was not waiting in the queue
+func (q *TaskQueue) Remove(ti *TaskInstance) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, item := range q.items {
+		if item.ti == ti {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Len number of tasks waiting in the queue
+func (q *TaskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Running number of tasks running in a pool
+func (q *TaskQueue) Running(pool string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running[pool]
+}
+
+// DagRunning number of tasks running for a dag across all of its runs
+func (q *TaskQueue) DagRunning(dagID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dagRunning[dagID]
+}
+
+// DagQueued number of tasks of a dag waiting in the queue
+func (q *TaskQueue) DagQueued(dagID string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := 0
+	for _, item := range q.items {
+		if item.ti.Task.GetDag().ID == dagID {
+			queued++
+		}
+	}
+	return queued
+}
+
+func (q *TaskQueue) refreshPools() {
+	if time.Since(q.poolsLoaded) < poolRefreshInterval {
+		return
+	}
+	pools, err := models.ListPools()
+	if err != nil {
+		logrus.Error(errors.Wrap(err, "list pools"))
+		return
+	}
+	poolSlots := map[string]int{}
+	for _, p := range pools {
+		poolSlots[p.Pool] = p.Slots
+	}
+	q.poolSlots = poolSlots
+	q.poolsLoaded = time.Now()
+}
+
+// next removes and returns the highest priority task that can run without going over
+// the concurrency of its dag or the slots of its pool. must hold the lock
+func (q *TaskQueue) next() *queuedTask {
+	sort.SliceStable(q.items, func(i, j int) bool {
+		if q.items[i].weight != q.items[j].weight {
+			return q.items[i].weight > q.items[j].weight
+		}
+		return q.items[i].seq < q.items[j].seq
+	})
+	for i, item := range q.items {
+		dag := item.ti.Task.GetDag()
+		if limit := dag.concurrency(); limit > 0 && q.dagRunning[dag.ID] >= limit {
+			continue
+		}
+		pool := item.ti.Task.GetPool()
+		if pool != "" {
+			slots, ok := q.poolSlots[pool]
+			if !ok {
+				item.err = errors.Errorf("pool %s does not exist", pool)
+			} else if q.running[pool] >= slots {
+				continue
+			}
+		}
+		if item.err == nil {
+			q.dagRunning[dag.ID]++
+			if pool != "" {
+				q.running[pool]++
+			}
+		}
+		q.items = append(q.items[:i], q.items[i+1:]...)
+		return item
+	}
+	return nil
+}
+
+// Pop blocks until a task can run or the context is cancelled
+func (q *TaskQueue) Pop(ctx context.Context) (*queuedTask, error) {
+	for {
+		q.mu.Lock()
+		q.refreshPools()
+		if item := q.next(); item != nil {
+			q.mu.Unlock()
+			return item, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(poolRefreshInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Done releases the dag concurrency and pool slot held by a task
+func (q *TaskQueue) Done(item *queuedTask) {
+	if item.err != nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dagRunning[item.ti.Task.GetDag().ID]--
+	if pool := item.ti.Task.GetPool(); pool != "" {
+		q.running[pool]--
+	}
+	q.broadcast()
+}
@@ -0,0 +1,82 @@
+package relay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityWeight(t *testing.T) {
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.Chain(tasks...))
+	assert.Equal(t, 3, priorityWeight(tasks[0]))
+	assert.Equal(t, 1, priorityWeight(tasks[2]))
+
+	tasks[2].(*BashOperator).WeightRule = WeightRuleUpstream
+	assert.Equal(t, 3, priorityWeight(tasks[2]))
+
+	tasks[0].(*BashOperator).WeightRule = WeightRuleAbsolute
+	tasks[0].(*BashOperator).PriorityWeight = 10
+	assert.Equal(t, 10, priorityWeight(tasks[0]))
+}
+
+func TestTaskQueuePool(t *testing.T) {
+	_, tasks := newTestDag(t, "low", "high", "other")
+	tasks[0].(*BashOperator).Pool = "db"
+	tasks[1].(*BashOperator).Pool = "db"
+	tasks[1].(*BashOperator).PriorityWeight = 5
+
+	queue := NewTaskQueue()
+	queue.poolSlots = map[string]int{"db": 1}
+	queue.poolsLoaded = time.Now().Add(time.Hour)
+	evalQueue := make(chan *TaskInstance, len(tasks))
+	for _, task := range tasks {
+		queue.Push(NewTaskInstance(task), evalQueue)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	first, err := queue.Pop(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "high", first.ti.Task.GetID())
+	second, err := queue.Pop(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "other", second.ti.Task.GetID(), "db pool is full")
+	_, err = queue.Pop(ctx)
+	assert.NotNil(t, err, "low waits for a db slot")
+
+	queue.Done(first)
+	third, err := queue.Pop(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "low", third.ti.Task.GetID())
+	assert.Equal(t, 1, queue.Running("db"))
+}
+
+func TestTaskQueueDagConcurrency(t *testing.T) {
+	dag, tasks := newTestDag(t, "t1", "t2")
+	dag.Concurrency = 1
+
+	queue := NewTaskQueue()
+	queue.poolsLoaded = time.Now().Add(time.Hour)
+	evalQueue := make(chan *TaskInstance, len(tasks))
+	for _, task := range tasks {
+		queue.Push(NewTaskInstance(task), evalQueue)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	first, err := queue.Pop(ctx)
+	assert.Nil(t, err)
+	_, err = queue.Pop(ctx)
+	assert.NotNil(t, err, "dag at concurrency")
+	assert.Equal(t, 1, queue.DagRunning(dag.ID))
+	assert.Equal(t, 1, queue.DagQueued(dag.ID))
+
+	queue.Done(first)
+	_, err = queue.Pop(context.Background())
+	assert.Nil(t, err)
+}
@@ -1,10 +1,27 @@
 package relay
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	// requests in tests have no user unless a test turns authentication on
+	config.DefaultConfig.Webserver.Authenticate = false
+	os.Exit(m.Run())
+}
+
 func TestNewDag(t *testing.T) {
 	dag, err := NewDag(&DagConfig{
 		ID:               "test",
@@ -72,3 +89,253 @@ func TestNewDag(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func newTestDag(t *testing.T, taskIDs ...string) (*DAG, []TaskInterface) {
+	dag, err := NewDag(&DagConfig{
+		ID:               "test",
+		ScheduleInterval: "* * * * *",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := []TaskInterface{}
+	for _, taskID := range taskIDs {
+		task, err := dag.NewBash(&BashOperator{TaskID: taskID, BashCommand: "date"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	return dag, tasks
+}
+
+func TestChain(t *testing.T) {
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.Chain(tasks...))
+	assert.Equal(t, []TaskInterface{tasks[1]}, tasks[0].downstreamList())
+	assert.Equal(t, []TaskInterface{tasks[2]}, tasks[1].downstreamList())
+	assert.True(t, tasks[0].IsRoot())
+	assert.False(t, tasks[2].IsRoot())
+}
+
+func TestCrossDownstream(t *testing.T) {
+	dag, tasks := newTestDag(t, "a1", "a2", "b1", "b2")
+	assert.Nil(t, dag.CrossDownstream(tasks[:2], tasks[2:]))
+	for _, task := range tasks[2:] {
+		assert.Len(t, task.upstreamList(), 2)
+	}
+}
+
+func TestCrossDownstreamLayers(t *testing.T) {
+	layers := [][]TaskInterface{}
+	dag, _ := newTestDag(t)
+	for i := 0; i < 12; i++ {
+		layer := []TaskInterface{}
+		for j := 0; j < 6; j++ {
+			task, err := dag.NewBash(&BashOperator{TaskID: fmt.Sprintf("l%d-%d", i, j), BashCommand: "date"})
+			assert.Nil(t, err)
+			layer = append(layer, task)
+		}
+		layers = append(layers, layer)
+	}
+	// from the bottom up, so every new edge checks for a path through the layers below
+	start := time.Now()
+	for i := len(layers) - 2; i >= 0; i-- {
+		assert.Nil(t, dag.CrossDownstream(layers[i], layers[i+1]))
+	}
+	assert.True(t, time.Since(start) < 5*time.Second, "took %v", time.Since(start))
+	assert.NotNil(t, dag.SetDependency("l11-0", "l0-0"), "cycle")
+}
+
+func TestSetDependency(t *testing.T) {
+	dag, _ := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.SetDependency("t1", "t2"))
+	assert.Nil(t, dag.SetDependency("t2", "t3"))
+	assert.NotNil(t, dag.SetDependency("t1", "t2"), "duplicate")
+	assert.NotNil(t, dag.SetDependency("t3", "t1"), "cycle")
+	assert.NotNil(t, dag.SetDependency("t1", "t1"), "self")
+	assert.NotNil(t, dag.SetDependency("t1", "missing"), "missing")
+}
+
+func migrate(t *testing.T) {
+	if err := db.Connection.AutoMigrate(models.Migrations...).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	migrate(t)
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.Chain(tasks...))
+
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	for _, task := range tasks {
+		taskModel := &models.TaskInstance{TaskID: task.GetID(), DagRunID: dagRun.ID, State: state.Success}
+		assert.Nil(t, taskModel.Create())
+	}
+
+	cleared, err := dag.Clear(dagRun, "^t2$", true, false)
+	assert.Nil(t, err)
+	assert.Len(t, cleared, 2)
+
+	taskModels, err := dag.taskModels(dagRun)
+	assert.Nil(t, err)
+	assert.Equal(t, state.Success, taskModels["t1"].State)
+	assert.Equal(t, state.None, taskModels["t2"].State)
+	assert.Equal(t, state.None, taskModels["t3"].State)
+
+	_, err = dag.Clear(dagRun, "missing", false, false)
+	assert.NotNil(t, err)
+}
+
+func TestMark(t *testing.T) {
+	migrate(t)
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	assert.Nil(t, dag.Chain(tasks...))
+
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	taskIDs, err := dag.selectTasks("t2", true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"t2", "t3"}, taskIDs)
+
+	marked, err := dag.Mark(dagRun, taskIDs, state.Skipped, "data loaded by hand")
+	assert.Nil(t, err)
+	assert.Len(t, marked, 2)
+	assert.Contains(t, marked[0].Message, "data loaded by hand")
+
+	_, err = dag.Mark(dagRun, taskIDs, state.Running, "")
+	assert.NotNil(t, err)
+}
+
+func TestConcurrentDagRuns(t *testing.T) {
+	migrate(t)
+	dag, err := NewDag(&DagConfig{ID: "concurrent", ScheduleInterval: "* * * * *", MaxActiveRuns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := []TaskInterface{}
+	for _, taskID := range []string{"t1", "t2", "t3"} {
+		task, err := dag.NewGo(&GoOperator{TaskID: taskID, GoFunc: func() error { time.Sleep(50 * time.Millisecond); return nil }})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	assert.Nil(t, dag.Chain(tasks...))
+
+	queue := NewTaskQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	}
+
+	var w sync.WaitGroup
+	runners := []*TaskRunner{}
+	for i := 0; i < 2; i++ {
+		dagRun := dag.DagRun()
+		assert.Nil(t, dagRun.Create())
+		runner := NewTaskRunner(dag.tasks, queue)
+		runners = append(runners, runner)
+		w.Add(1)
+		go func() {
+			defer w.Done()
+			assert.Nil(t, dag.Run(ctx, runner, dagRun))
+		}()
+	}
+	w.Wait()
+	for _, runner := range runners {
+		assert.Equal(t, state.Success, runner.FinalState())
+		for _, ti := range runner.Tasks {
+			assert.Equal(t, state.Success, ti.State)
+			assert.Equal(t, 1, ti.TryNumber())
+		}
+	}
+}
+
+func TestRerunDagRun(t *testing.T) {
+	migrate(t)
+	dag, err := NewDag(&DagConfig{ID: "rerun", ScheduleInterval: "* * * * *"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	runs := map[string]int{}
+	tasks := []TaskInterface{}
+	for _, taskID := range []string{"t1", "t2", "t3", "t4"} {
+		taskID := taskID
+		task, err := dag.NewGo(&GoOperator{TaskID: taskID, GoFunc: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			runs[taskID]++
+			return nil
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	assert.Nil(t, dag.Chain(tasks...))
+
+	queue := NewTaskQueue()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+
+	// task instances kept from an earlier attempt are evaluated in any order, run it a few times
+	for i := 0; i < 5; i++ {
+		dagRun := dag.DagRun()
+		assert.Nil(t, dagRun.Create())
+		for _, taskID := range []string{"t1", "t2"} {
+			taskModel := &models.TaskInstance{TaskID: taskID, DagRunID: dagRun.ID, State: state.Success, TryNumber: 1}
+			assert.Nil(t, taskModel.Create())
+		}
+		mu.Lock()
+		runs = map[string]int{}
+		mu.Unlock()
+		runner := NewTaskRunner(dag.tasks, queue)
+		assert.Nil(t, dag.Run(ctx, runner, dagRun))
+		assert.Equal(t, state.Success, runner.FinalState())
+		mu.Lock()
+		assert.Equal(t, map[string]int{"t3": 1, "t4": 1}, runs, "downstream tasks run once")
+		mu.Unlock()
+	}
+}
+
+func TestRerunOnce(t *testing.T) {
+	dag, _ := newTestDag(t, "t1")
+	dagRun := dag.DagRun()
+	dagRun.ID = 1
+	runner := NewDagRunner()
+	runner.Executor = &localExecutor{parallelism: 1}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go runner.Run(ctx)
+
+	preparing, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- runner.Rerun(dag, dagRun, func() error {
+			close(preparing)
+			<-release
+			return errors.New("clear failed")
+		})
+	}()
+	<-preparing
+	assert.True(t, runner.IsActive(dagRun), "active while it is cleared")
+	assert.Equal(t, errDagRunActive, runner.Rerun(dag, dagRun, nil), "second rerun")
+	close(release)
+	assert.EqualError(t, <-done, "clear failed")
+	assert.False(t, runner.IsActive(dagRun))
+
+	cancel()
+	go func() { done <- runner.Rerun(dag, dagRun, nil) }()
+	select {
+	case err := <-done:
+		assert.NotNil(t, err, "runner is stopped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("rerun blocked on a stopped runner")
+	}
+}
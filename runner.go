@@ -3,70 +3,352 @@ package relay
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
 	"github.com/estenssoros/relay/state"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// DagRunner runs dags
+// DagRunner runs dags. Runs over a dag's max active runs wait in a queue until
+// an earlier run of the dag finishes
 type DagRunner struct {
-	dagChan chan *DAG
-	Error   chan error
-	Sema    chan struct{}
+	dagChan  chan *scheduledRun
+	rerun    chan *pendingRun
+	finished chan *pendingRun
+	Error    chan error
+	Queue    *TaskQueue
+	Executor Executor
+	Job      *models.Job
+	mu       sync.Mutex
+	active   map[string]int
+	pending  map[string][]*pendingRun
+	running  map[int]*pendingRun
+	// reruns dag runs being prepared for a rerun, which count as active
+	reruns  map[int]bool
+	stopped chan struct{}
+	workers sync.WaitGroup
+
+	taskCtx   context.Context
+	stopTasks context.CancelFunc
+}
+
+// scheduledRun a dag to run for an execution date
+type scheduledRun struct {
+	dag           *DAG
+	executionDate time.Time
+}
+
+// pendingRun a dag run waiting to start
+type pendingRun struct {
+	dag    *DAG
+	dagRun *models.DagRun
+	runner *TaskRunner
+}
+
+// DagStatus active and queued runs and tasks of a dag along with its limits
+type DagStatus struct {
+	DagID         string `json:"dagID"`
+	ActiveRuns    int    `json:"activeRuns"`
+	QueuedRuns    int    `json:"queuedRuns"`
+	MaxActiveRuns int    `json:"maxActiveRuns"`
+	RunningTasks  int    `json:"runningTasks"`
+	QueuedTasks   int    `json:"queuedTasks"`
+	Concurrency   int    `json:"concurrency"`
 }
 
 // NewDagRunner creates a new dag runner
 func NewDagRunner() *DagRunner {
+	taskCtx, stopTasks := context.WithCancel(context.Background())
 	return &DagRunner{
-		dagChan: make(chan *DAG),
-		Error:   make(chan error),
-		Sema:    make(chan struct{}, config.DefaultConfig.Core.DagConcurrency),
+		taskCtx:   taskCtx,
+		stopTasks: stopTasks,
+		dagChan:   make(chan *scheduledRun),
+		rerun:     make(chan *pendingRun),
+		finished:  make(chan *pendingRun),
+		Error:     make(chan error),
+		Queue:     NewTaskQueue(),
+		Executor:  &localExecutor{parallelism: config.DefaultConfig.Core.Parallelism},
+		active:    map[string]int{},
+		pending:   map[string][]*pendingRun{},
+		running:   map[int]*pendingRun{},
+		reruns:    map[int]bool{},
+		stopped:   make(chan struct{}),
 	}
 }
 
-// Run waits for dags on a dag chan
+// SpawnWorkers spawns the workers shared by all dag runs. The number of workers
+// is set by the slots of the executor and caps the number of tasks running at once.
+// Workers stop taking tasks when ctx is cancelled
+func (r *DagRunner) SpawnWorkers(ctx context.Context) {
+	numWorkers := r.Executor.Slots()
+	for i := 0; i < numWorkers; i++ {
+		r.workers.Add(1)
+		worker := NewWorker(r.Executor)
+		worker.job = r.Job
+		go func() {
+			defer r.workers.Done()
+			worker.Start(ctx, r.taskCtx, r.Queue)
+		}()
+	}
+	logrus.Infof("starting %d workers", numWorkers)
+}
+
+// Wait waits for the workers to finish their running tasks and exit
+func (r *DagRunner) Wait() {
+	r.workers.Wait()
+}
+
+// StopTasks stops running tasks. Workers set interrupted tasks up for retry
+func (r *DagRunner) StopTasks() {
+	r.stopTasks()
+}
+
+// Run waits for dags on a dag chan. Every dag received creates a queued dag run
+// which starts as soon as the dag is under its max active runs
 func (r *DagRunner) Run(ctx context.Context) {
+	defer close(r.stopped)
+	r.SpawnWorkers(ctx)
 	for {
 		select {
-		case dag := <-r.dagChan:
-			r.Sema <- struct{}{} // limitis dag concurrency by semaphore
-			defer func() {
-				<-r.Sema
-			}()
-
-			dagRun := dag.DagRun()
+		case run := <-r.dagChan:
+			dag := run.dag
+			dagRun := dag.DagRunAt(run.executionDate)
 			if err := dagRun.Create(); err != nil {
-				r.Error <- errors.Wrap(err, "dag run create")
-			}
-			if err := dag.Run(ctx, dagRun); err != nil {
-				r.Error <- errors.Wrapf(err, "%s", dag.FormattedID())
+				if exists, _ := models.DagRunExists(dag.ID, run.executionDate); exists {
+					logrus.Infof("%s already has a run for %v", dag.FormattedID(), run.executionDate)
+					continue
+				}
+				r.sendError(ctx, errors.Wrap(err, "dag run create"))
+				continue
 			}
+			publishDagRun(dagRun)
+			r.mu.Lock()
+			r.pending[dag.ID] = append(r.pending[dag.ID], &pendingRun{dag: dag, dagRun: dagRun})
+			r.mu.Unlock()
+			r.startRuns(ctx, dag)
+		case run := <-r.rerun:
+			r.mu.Lock()
+			delete(r.reruns, run.dagRun.ID)
+			r.pending[run.dag.ID] = append(r.pending[run.dag.ID], run)
+			r.mu.Unlock()
+			r.startRuns(ctx, run.dag)
+		case run := <-r.finished:
+			r.mu.Lock()
+			r.active[run.dag.ID]--
+			delete(r.running, run.dagRun.ID)
+			r.mu.Unlock()
+			r.startRuns(ctx, run.dag)
 		case <-ctx.Done():
 			logrus.Info("closing dag runner...")
 			return
 		}
 	}
+}
+
+// startRuns starts queued runs of a dag while it is under its max active runs
+func (r *DagRunner) startRuns(ctx context.Context, dag *DAG) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.pending[dag.ID]) > 0 {
+		if limit := dag.maxActiveRuns(); limit > 0 && r.active[dag.ID] >= limit {
+			logrus.Infof("%s at %d max active runs, %d runs queued", dag.FormattedID(), limit, len(r.pending[dag.ID]))
+			return
+		}
+		run := r.pending[dag.ID][0]
+		r.pending[dag.ID] = r.pending[dag.ID][1:]
+		r.active[dag.ID]++
+		run.runner = NewTaskRunner(dag.tasks, r.Queue)
+		r.running[run.dagRun.ID] = run
+		go r.runDag(ctx, run)
+	}
+}
+
+func (r *DagRunner) runDag(ctx context.Context, run *pendingRun) {
+	if err := run.dag.Run(ctx, run.runner, run.dagRun); err != nil {
+		r.sendError(ctx, errors.Wrapf(err, "%s", run.dag.FormattedID()))
+	}
+	select {
+	case r.finished <- run:
+	case <-ctx.Done():
+	}
+}
 
+func (r *DagRunner) sendError(ctx context.Context, err error) {
+	select {
+	case r.Error <- err:
+	case <-ctx.Done():
+	}
 }
 
 // RunDag  sends a dag to be run
 func (r *DagRunner) RunDag(dag *DAG) {
-	r.dagChan <- dag
+	r.ScheduleDag(dag, time.Now().UTC())
+}
+
+// ScheduleDag sends a dag to be run for an execution date. A dag only runs once per
+// execution date, even across schedulers
+func (r *DagRunner) ScheduleDag(dag *DAG, executionDate time.Time) {
+	r.dagChan <- &scheduledRun{dag: dag, executionDate: executionDate}
+}
+
+// errDagRunActive a dag run cannot be rerun while it is running or waiting to run
+var errDagRunActive = errors.New("dag run is active")
+
+// Rerun runs an existing dag run again. Tasks that finished in an earlier attempt
+// keep their state and only cleared tasks are run. prepare, which may be nil, runs first
+// and can clear tasks: the dag run counts as active from the start so no other rerun or
+// clear gets to it. Fails when the dag run is already active or the runner has stopped
+func (r *DagRunner) Rerun(dag *DAG, dagRun *models.DagRun, prepare func() error) error {
+	r.mu.Lock()
+	if r.isActive(dagRun) {
+		r.mu.Unlock()
+		return errDagRunActive
+	}
+	r.reruns[dagRun.ID] = true
+	r.mu.Unlock()
+	release := func() {
+		r.mu.Lock()
+		delete(r.reruns, dagRun.ID)
+		r.mu.Unlock()
+	}
+	if prepare != nil {
+		if err := prepare(); err != nil {
+			release()
+			return err
+		}
+	}
+	select {
+	case r.rerun <- &pendingRun{dag: dag, dagRun: dagRun}:
+		return nil
+	case <-r.stopped:
+		release()
+		return errors.New("dag runner is stopped")
+	}
 }
 
-// TaskRunner runs tasks in a dag
+// Mark sets the state of tasks in a dag run with an audit note. Tasks of a running
+// dag run are marked through its task runner so the new states are picked up
+func (r *DagRunner) Mark(dag *DAG, dagRun *models.DagRun, taskIDs []string, s state.State, note string) ([]*models.TaskInstance, error) {
+	if !isMarkable(s) {
+		return nil, errors.Errorf("cannot mark tasks %s", s)
+	}
+	r.mu.Lock()
+	run, ok := r.running[dagRun.ID]
+	r.mu.Unlock()
+	if !ok {
+		return dag.Mark(dagRun, taskIDs, s, note)
+	}
+	marked := []*models.TaskInstance{}
+	for _, taskID := range taskIDs {
+		model, err := run.runner.Mark(taskID, s, note)
+		if err != nil {
+			return marked, errors.Wrapf(err, "mark %s", taskID)
+		}
+		marked = append(marked, model)
+	}
+	return marked, nil
+}
+
+// Cancel cancels an active dag run. A dag run waiting to start fails right away. Running
+// task instances of a running dag run are killed and the rest are skipped
+func (r *DagRunner) Cancel(dagRun *models.DagRun) error {
+	r.mu.Lock()
+	for i, run := range r.pending[dagRun.DagID] {
+		if run.dagRun.ID == dagRun.ID {
+			r.pending[dagRun.DagID] = append(r.pending[dagRun.DagID][:i], r.pending[dagRun.DagID][i+1:]...)
+			r.mu.Unlock()
+			logrus.Infof("cancelled queued run %d of DAG[%s]", dagRun.ID, dagRun.DagID)
+			if err := dagRun.Finish(state.Failed); err != nil {
+				return err
+			}
+			publishDagRun(dagRun)
+			recordDagRun(dagRun)
+			return nil
+		}
+	}
+	run, ok := r.running[dagRun.ID]
+	r.mu.Unlock()
+	if !ok {
+		return errors.New("dag run is not active")
+	}
+	logrus.Infof("cancelling %s run %d", run.dag.FormattedID(), dagRun.ID)
+	return run.runner.Cancel()
+}
+
+// Kill stops a running task of an active dag run
+func (r *DagRunner) Kill(dagRun *models.DagRun, taskID string) error {
+	r.mu.Lock()
+	run, ok := r.running[dagRun.ID]
+	r.mu.Unlock()
+	if !ok {
+		return errors.New("dag run is not running")
+	}
+	return run.runner.Kill(taskID)
+}
+
+// IsActive checks if a dag run is running or waiting to run
+func (r *DagRunner) IsActive(dagRun *models.DagRun) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.isActive(dagRun)
+}
+
+// isActive checks if a dag run is running, waiting to run or being prepared for a rerun.
+// The caller holds r.mu
+func (r *DagRunner) isActive(dagRun *models.DagRun) bool {
+	if r.reruns[dagRun.ID] {
+		return true
+	}
+	for _, run := range r.pending[dagRun.DagID] {
+		if run.dagRun.ID == dagRun.ID {
+			return true
+		}
+	}
+	_, ok := r.running[dagRun.ID]
+	return ok
+}
+
+// Status reports the active and queued runs and tasks of a dag
+func (r *DagRunner) Status(dag *DAG) *DagStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &DagStatus{
+		DagID:         dag.ID,
+		ActiveRuns:    r.active[dag.ID],
+		QueuedRuns:    len(r.pending[dag.ID]),
+		MaxActiveRuns: dag.maxActiveRuns(),
+		RunningTasks:  r.Queue.DagRunning(dag.ID),
+		QueuedTasks:   r.Queue.DagQueued(dag.ID),
+		Concurrency:   dag.concurrency(),
+	}
+}
+
+// TaskRunner runs the task instances of a dag run
 type TaskRunner struct {
-	evalQueue      chan TaskInterface
-	taskQueue      chan TaskInterface
+	evalQueue      chan *TaskInstance
+	queue          *TaskQueue
 	Error          chan error
-	Tasks          map[string]TaskInterface
+	Tasks          map[string]*TaskInstance
 	Done           chan struct{}
-	success        []TaskInterface
-	failed         []TaskInterface
-	upstreamFailed []TaskInterface
-	workers        []*Worker
+	marks          chan *mark
+	cancels        chan struct{}
+	cancelled      bool
+	stopped        chan struct{}
+	success        []*TaskInstance
+	failed         []*TaskInstance
+	upstreamFailed []*TaskInstance
+}
+
+// mark a manual state change for a task of a running dag run
+type mark struct {
+	taskID string
+	state  state.State
+	note   string
+	model  *models.TaskInstance
+	err    chan error
 }
 
 // Check to see if the task runner can run tasks
@@ -74,20 +356,33 @@ func (r *TaskRunner) Check() error {
 	if config.DefaultConfig.Core.Parallelism == 0 {
 		return errors.New("parallelism set to 0: no tasks will run")
 	}
+	switch config.DefaultConfig.Core.TaskRunner {
+	case "", "StandardTaskRunner", "StandardTaksRunner":
+	default:
+		return errors.Errorf("unknown task runner: %s", config.DefaultConfig.Core.TaskRunner)
+	}
 	return nil
 }
 
-// NewTaskRunner creates a new task runner
-func NewTaskRunner(tasks map[string]TaskInterface) *TaskRunner {
+// NewTaskRunner creates a new task runner with a pending task instance for each task.
+// Runnable task instances are sent to the shared task queue
+func NewTaskRunner(tasks map[string]TaskInterface, queue *TaskQueue) *TaskRunner {
+	instances := map[string]*TaskInstance{}
+	for taskID, task := range tasks {
+		instances[taskID] = NewTaskInstance(task)
+	}
 	return &TaskRunner{
-		evalQueue:      make(chan TaskInterface, len(tasks)),
-		taskQueue:      make(chan TaskInterface, len(tasks)),
+		evalQueue:      make(chan *TaskInstance, len(tasks)),
+		queue:          queue,
 		Error:          make(chan error),
 		Done:           make(chan struct{}),
-		Tasks:          tasks,
-		success:        []TaskInterface{},
-		failed:         []TaskInterface{},
-		upstreamFailed: []TaskInterface{},
+		marks:          make(chan *mark),
+		cancels:        make(chan struct{}),
+		stopped:        make(chan struct{}),
+		Tasks:          instances,
+		success:        []*TaskInstance{},
+		failed:         []*TaskInstance{},
+		upstreamFailed: []*TaskInstance{},
 	}
 }
 
@@ -96,9 +391,28 @@ func (r *TaskRunner) IsDone() bool {
 	return len(r.success)+len(r.failed)+len(r.upstreamFailed) == len(r.Tasks)
 }
 
-func (r *TaskRunner) isUpstreamFailed(task TaskInterface) bool {
-	for _, t := range task.upstreamList() {
-		switch t.GetState() {
+// instances gets the task instances of this run for a list of tasks
+func (r *TaskRunner) instances(tasks []TaskInterface) []*TaskInstance {
+	lst := []*TaskInstance{}
+	for _, t := range tasks {
+		if ti, ok := r.Tasks[t.GetID()]; ok {
+			lst = append(lst, ti)
+		}
+	}
+	return lst
+}
+
+func (r *TaskRunner) upstream(ti *TaskInstance) []*TaskInstance {
+	return r.instances(ti.Task.upstreamList())
+}
+
+func (r *TaskRunner) downstream(ti *TaskInstance) []*TaskInstance {
+	return r.instances(ti.Task.downstreamList())
+}
+
+func (r *TaskRunner) isUpstreamFailed(ti *TaskInstance) bool {
+	for _, t := range r.upstream(ti) {
+		switch t.State {
 		case state.Failed, state.UpstreamFailed:
 			return true
 		}
@@ -106,109 +420,245 @@ func (r *TaskRunner) isUpstreamFailed(task TaskInterface) bool {
 	return false
 }
 
-func (r *TaskRunner) isUpstreamSuccess(task TaskInterface) bool {
-	for _, t := range task.upstreamList() {
-		if t.GetState() != state.Success {
+func (r *TaskRunner) isUpstreamSuccess(ti *TaskInstance) bool {
+	for _, t := range r.upstream(ti) {
+		switch t.State {
+		case state.Success, state.Skipped:
+		default:
 			return false
 		}
 	}
 	return true
 }
 
-// Evaluate evaluate tasks state and distribute to workers or lists
-// Pending tasks are recycled through the task queue until their upstream tasks reach an actionable state
+// Evaluate evaluates the task instances of the dag run then the task instances sent back
+// by the workers along with manual marks until every task is accounted for
 func (r *TaskRunner) Evaluate(ctx context.Context) {
-	for {
+	r.seed()
+	for !r.IsDone() {
 		select {
-		case task := <-r.evalQueue:
-			switch task.GetState() {
-			case state.Queued: // start task
-				task.GetModel().Start()
-				r.taskQueue <- task
-				continue
+		case ti := <-r.evalQueue:
+			r.evaluate(ti)
+		case m := <-r.marks:
+			m.err <- r.applyMark(m)
+		case <-r.cancels:
+			r.applyCancel()
+		case <-ctx.Done():
+			logrus.Info("shutting down task evaluator...")
+			close(r.stopped)
+			return
+		}
+	}
+	r.close()
+}
 
-			case state.Success: // add to success
-				task.GetModel().State = state.Success
-				task.GetModel().Stop()
-				r.success = append(r.success, task)
+// seed evaluates task instances kept from an earlier attempt first so their pending
+// downstream task instances are only sent to the workers once
+func (r *TaskRunner) seed() {
+	for _, ti := range r.Tasks {
+		if isFinished(ti.State) {
+			r.evaluate(ti)
+		}
+	}
+	for _, ti := range r.Tasks {
+		if ti.State == state.Pending {
+			r.evaluate(ti)
+		}
+	}
+}
 
-			case state.Failed: // fail downstream tasks
-				task.GetModel().State = state.Failed
-				task.GetModel().Stop()
-				for _, t := range task.downstreamList() {
-					r.upstreamFailed = append(r.upstreamFailed, t)
-				}
-				r.failed = append(r.failed, task)
-
-			case state.Pending: // check if runnable
-				if r.isUpstreamFailed(task) {
-					task.SetState(state.UpstreamFailed)
-					for _, t := range task.downstreamList() {
-						t.SetState(state.UpstreamFailed)
-					}
-					r.evalQueue <- task
-					continue
-				}
-				if r.isUpstreamSuccess(task) {
-					task.SetState(state.Queued)
-					task.GetModel().State = state.Queued
-					task.GetModel().Update()
-					logrus.Infof("%s sent to workers", task.FormattedID())
-					r.taskQueue <- task
-					continue
-				}
-			case state.UpstreamFailed:
-				task.GetModel().State = state.UpstreamFailed
-				task.GetModel().Update()
-				r.upstreamFailed = append(r.upstreamFailed, task)
-				continue
-			}
+// evaluate moves a task instance along based on its state. Finished task instances are
+// added to their list and their pending downstream task instances are evaluated in turn.
+// Pending task instances are sent to workers once all upstream tasks succeed
+func (r *TaskRunner) evaluate(ti *TaskInstance) {
+	switch ti.State {
+	case state.Queued: // back from a worker which stored the result on the model
+		ti.State = ti.Model.State
+		publishTaskInstance(ti, ti.State)
+		recordTry(ti)
+		r.evaluate(ti)
+		return
 
-			if r.IsDone() {
-				for _, w := range r.workers {
-					w.kill <- struct{}{}
-				}
-				close(r.evalQueue)
-				close(r.taskQueue)
-				r.Done <- struct{}{}
-				return
+	case state.Success, state.Skipped:
+		r.success = append(r.success, ti)
+
+	case state.Failed:
+		r.failed = append(r.failed, ti)
+
+	case state.UpstreamFailed:
+		ti.Model.State = state.UpstreamFailed
+		ti.Model.Update()
+		publishTaskInstance(ti, ti.State)
+		r.upstreamFailed = append(r.upstreamFailed, ti)
+
+	default: // check if runnable
+		if r.cancelled {
+			r.skip(ti)
+		} else if r.isUpstreamFailed(ti) {
+			ti.State = state.UpstreamFailed
+			r.evaluate(ti)
+		} else if r.isUpstreamSuccess(ti) {
+			ti.State = state.Queued
+			ti.Model.State = state.Queued
+			ti.Model.Update()
+			publishTaskInstance(ti, ti.State)
+			logrus.Infof("%s sent to workers", ti.FormattedID())
+			r.queue.Push(ti, r.evalQueue)
+		}
+		return
+	}
+	for _, t := range r.downstream(ti) {
+		if t.State == state.Pending {
+			r.evaluate(t)
+		}
+	}
+}
+
+func (r *TaskRunner) close() {
+	close(r.stopped)
+	close(r.evalQueue)
+	r.Done <- struct{}{}
+}
+
+// Mark sets the state of a task of the running dag run. Tasks that are running cannot be marked
+func (r *TaskRunner) Mark(taskID string, s state.State, note string) (*models.TaskInstance, error) {
+	m := &mark{
+		taskID: taskID,
+		state:  s,
+		note:   note,
+		err:    make(chan error, 1),
+	}
+	select {
+	case r.marks <- m:
+	case <-r.stopped:
+		return nil, errors.New("dag run finished")
+	}
+	if err := <-m.err; err != nil {
+		return nil, err
+	}
+	return m.model, nil
+}
+
+// applyMark sets the state of a task instance. Finished task instances are taken off their
+// list and downstream task instances that failed because of them go back to pending
+// before the task instance is evaluated again with its new state
+func (r *TaskRunner) applyMark(m *mark) error {
+	ti, ok := r.Tasks[m.taskID]
+	if !ok {
+		return errors.Errorf("missing task %s", m.taskID)
+	}
+	if ti.State == state.Queued && !r.queue.Remove(ti) {
+		return errors.Errorf("%s is running", ti.FormattedID())
+	}
+	r.unfinish(ti)
+	if err := ti.Model.Mark(m.state, m.note); err != nil {
+		return errors.Wrap(err, "mark model")
+	}
+	ti.State = m.state
+	publishTaskInstance(ti, ti.State)
+	if m.state != state.Failed {
+		r.resetUpstreamFailed(ti)
+	}
+	r.evaluate(ti)
+	m.model = ti.Model
+	return nil
+}
+
+// Cancel cancels the running dag run. Running task instances are killed and fail, task
+// instances that have not run are skipped and the dag run fails
+func (r *TaskRunner) Cancel() error {
+	select {
+	case r.cancels <- struct{}{}:
+		return nil
+	case <-r.stopped:
+		return errors.New("dag run finished")
+	}
+}
+
+// applyCancel kills the running task instances and skips the ones that have not run. Task
+// instances coming back from the workers are skipped instead of run again
+func (r *TaskRunner) applyCancel() {
+	r.cancelled = true
+	for _, ti := range r.Tasks {
+		switch ti.State {
+		case state.Queued:
+			if r.queue.Remove(ti) {
+				r.skip(ti)
+			} else if ti.cancel() {
+				logrus.Infof("killing %s", ti.FormattedID())
 			}
-			r.evalQueue <- task
+		case state.Success, state.Skipped, state.Failed, state.UpstreamFailed:
+		default:
+			r.skip(ti)
+		}
+	}
+}
 
-		case <-ctx.Done():
-			logrus.Info("shutting down task evaluator...")
-			return
+// skip skips a task instance of a cancelled dag run
+func (r *TaskRunner) skip(ti *TaskInstance) {
+	if err := ti.Model.Mark(state.Skipped, "dag run cancelled"); err != nil {
+		logrus.Error(errors.Wrapf(err, "skip %s", ti.FormattedID()))
+	}
+	ti.State = state.Skipped
+	publishTaskInstance(ti, ti.State)
+	r.success = append(r.success, ti)
+}
+
+// Kill stops a running task of the dag run. The task instance fails
+func (r *TaskRunner) Kill(taskID string) error {
+	ti, ok := r.Tasks[taskID]
+	if !ok {
+		return errors.Errorf("missing task %s", taskID)
+	}
+	if !ti.kill() {
+		return errors.Errorf("%s is not running", ti.FormattedID())
+	}
+	logrus.Infof("killing %s", ti.FormattedID())
+	return nil
+}
+
+// resetUpstreamFailed sends downstream task instances that failed because of an upstream failure back to pending
+func (r *TaskRunner) resetUpstreamFailed(ti *TaskInstance) {
+	for _, t := range r.downstream(ti) {
+		if t.State != state.UpstreamFailed {
+			continue
 		}
+		r.unfinish(t)
+		t.State = state.Pending
+		t.Model.State = state.Pending
+		t.Model.Update()
+		r.resetUpstreamFailed(t)
 	}
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+func remove(lst []*TaskInstance, ti *TaskInstance) ([]*TaskInstance, bool) {
+	for i, t := range lst {
+		if t == ti {
+			return append(lst[:i], lst[i+1:]...), true
+		}
 	}
-	return b
+	return lst, false
 }
 
-// SpawnWorkers spawn workers to hand tasks. Defaults to the minimum between number of
-// tasks and config specified workers
-func (r *TaskRunner) SpawnWorkers(ctx context.Context) {
-	numWorkers := min(config.DefaultConfig.Core.Parallelism, len(r.Tasks))
-	for i := 0; i < numWorkers; i++ {
-		worker := NewWorker()
-		r.workers = append(r.workers, worker)
-		go worker.Start(ctx, r.taskQueue, r.evalQueue)
+// unfinish takes a task instance off of the finished lists
+func (r *TaskRunner) unfinish(ti *TaskInstance) {
+	var ok bool
+	if r.success, ok = remove(r.success, ti); ok {
+		return
 	}
-	logrus.Infof("starting %d workers", numWorkers)
+	if r.failed, ok = remove(r.failed, ti); ok {
+		return
+	}
+	r.upstreamFailed, _ = remove(r.upstreamFailed, ti)
 }
 
-// Run run the task runner. This startrs the task evaluator and spans workers for the tasks
-// it then waits on the runner.Done channel or for a context cancel
+// Run run the task runner. This startrs the task evaluator which hands tasks to the shared
+// workers. It then waits on the runner.Done channel or for a context cancel
 func (r *TaskRunner) Run(ctx context.Context, w *sync.WaitGroup) {
 	defer w.Done()
 
 	go r.Evaluate(ctx)
 
-	r.SpawnWorkers(ctx)
 	for {
 		select {
 		case <-r.Done:
@@ -224,7 +674,7 @@ func (r *TaskRunner) Run(ctx context.Context, w *sync.WaitGroup) {
 
 // FinalState calculate the final state based on the length of task lists
 func (r *TaskRunner) FinalState() state.State {
-	if len(r.failed) != 0 {
+	if len(r.failed) != 0 || r.cancelled {
 		return state.Failed
 	}
 	return state.Success
@@ -0,0 +1,170 @@
+package relay
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// nextRun the next time a dag is due to run. When working out the next run failed, next
+// is when to try again to work out the run after last instead, or after the last run in
+// the relay database when last is zero
+type nextRun struct {
+	dag      *DAG
+	next     time.Time
+	last     time.Time
+	failures int
+}
+
+// nextRunHeap min-heap of next runs, earliest first
+type nextRunHeap []*nextRun
+
+func (h nextRunHeap) Len() int            { return len(h) }
+func (h nextRunHeap) Less(i, j int) bool  { return h[i].next.Before(h[j].next) }
+func (h nextRunHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nextRunHeap) Push(x interface{}) { *h = append(*h, x.(*nextRun)) }
+func (h *nextRunHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// idleWait how long the schedule loop sleeps when no dag has a next run
+func idleWait() time.Duration {
+	if sec := config.DefaultConfig.Scheduler.SchedulerHeartBeatSec; sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return time.Minute
+}
+
+// maxRetryWait longest wait before working out the next run of a dag again after it failed
+var maxRetryWait = 10 * time.Minute
+
+// retryWait how long to wait before working out the next run of a dag again after it
+// failed, doubling from a second with each failure up to maxRetryWait
+func retryWait(failures int) time.Duration {
+	if failures > 30 {
+		return maxRetryWait
+	}
+	if wait := time.Second << uint(failures); wait < maxRetryWait {
+		return wait
+	}
+	return maxRetryWait
+}
+
+// wakeUp wakes the schedule loop so it sees a changed earliest next run
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pushNextRun schedules the next run of a dag. must hold the lock
+func (s *Scheduler) pushNextRun(dag *DAG, next time.Time) {
+	if next.IsZero() {
+		logrus.Infof("%s has no more runs scheduled", dag.FormattedID())
+		return
+	}
+	heap.Push(&s.nextRuns, &nextRun{dag: dag, next: next})
+	s.wakeUp()
+}
+
+// scheduleAfter schedules the run of a dag following last, or following its last run in
+// the relay database when last is zero. When that fails it is tried again later, so a
+// dag with a timetable error is not dropped from the schedule. must hold the lock
+func (s *Scheduler) scheduleAfter(dag *DAG, last, now time.Time, failures int) {
+	var next time.Time
+	var err error
+	if last.IsZero() {
+		next, err = dag.NextRun()
+	} else {
+		next, err = dag.nextRun(last, now)
+	}
+	if err != nil {
+		wait := retryWait(failures)
+		logrus.Error(errors.Wrapf(err, "%s next run, trying again in %v", dag.FormattedID(), wait))
+		heap.Push(&s.nextRuns, &nextRun{dag: dag, next: now.Add(wait), last: last, failures: failures + 1})
+		s.wakeUp()
+		return
+	}
+	s.pushNextRun(dag, next)
+}
+
+// resetNextRuns schedules every dag from its last run. Runs that came due while the
+// scheduler was not leading are skipped unless the dag catches up
+func (s *Scheduler) resetNextRuns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRuns = nextRunHeap{}
+	now := time.Now().UTC()
+	for _, dag := range s.Dags.List() {
+		s.scheduleAfter(dag, time.Time{}, now, 0)
+	}
+}
+
+// dueRuns pops the runs due at now and moves their dags on to the following run. Each
+// next run is only returned once. Dags whose next run could not be worked out are tried
+// again. Also returns how long until the earliest next run
+func (s *Scheduler) dueRuns(now time.Time) ([]*scheduledRun, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := []*scheduledRun{}
+	for len(s.nextRuns) > 0 && !s.nextRuns[0].next.After(now) {
+		entry := heap.Pop(&s.nextRuns).(*nextRun)
+		if entry.failures > 0 {
+			s.scheduleAfter(entry.dag, entry.last, now, entry.failures)
+			continue
+		}
+		due = append(due, &scheduledRun{dag: entry.dag, executionDate: entry.next})
+		s.scheduleAfter(entry.dag, entry.next, now, 0)
+	}
+	if len(s.nextRuns) == 0 {
+		return due, idleWait()
+	}
+	return due, s.nextRuns[0].next.Sub(now)
+}
+
+// schedule sends dags to ch as their next runs come due. It sleeps until the earliest
+// next run, waking early when dags are added or removed. It runs at least every heartbeat
+// and records each run, which the scheduler job reports as its loop's liveness
+func (s *Scheduler) schedule(ctx context.Context, ch chan<- *scheduledRun) {
+	for {
+		now := time.Now().UTC()
+		recordSchedulerLoop(now)
+		due, wait := s.dueRuns(now)
+		if wait > heartbeatInterval() {
+			wait = heartbeatInterval()
+		}
+		for _, run := range due {
+			if run.dag.isPaused() {
+				logrus.Infof("%s is paused, skipping run %v", run.dag.FormattedID(), run.executionDate)
+				continue
+			}
+			if err := run.dag.updateNextScheduled(); err != nil {
+				logrus.Error(errors.Wrapf(err, "%s update next scheduled", run.dag.FormattedID()))
+			}
+			select {
+			case ch <- run:
+			case <-ctx.Done():
+				return
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			logrus.Infof("closing scheduler...")
+			return
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newSecondlyDag(t *testing.T, dagID string) *DAG {
+	dag, err := NewDag(&DagConfig{
+		ID:               dagID,
+		ScheduleInterval: "* * * * * * *", // every second
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dag
+}
+
+func TestScheduleNoDuplicateRuns(t *testing.T) {
+	migrate(t)
+	s := NewScheduler()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, s.AddDag(newSecondlyDag(t, fmt.Sprintf("secondly%d", i))))
+	}
+	assert.NotNil(t, s.AddDag(newSecondlyDag(t, "secondly0")), "already registered")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3500*time.Millisecond)
+	defer cancel()
+	ch := make(chan *scheduledRun)
+	go s.schedule(ctx, ch)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // add and remove a dag while the scheduler runs
+		defer wg.Done()
+		for {
+			s.AddDag(newSecondlyDag(t, "churn"))
+			s.Dags.List()
+			time.Sleep(10 * time.Millisecond)
+			s.RemoveDag("churn")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	removed := make(chan time.Time, 1)
+	time.AfterFunc(1500*time.Millisecond, func() {
+		assert.Nil(t, s.RemoveDag("secondly2"))
+		removed <- time.Now().UTC()
+	})
+
+	runs := map[string][]time.Time{}
+	for done := false; !done; {
+		select {
+		case run := <-ch:
+			runs[run.dag.ID] = append(runs[run.dag.ID], run.executionDate)
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	wg.Wait()
+
+	for _, dagID := range []string{"secondly0", "secondly1"} {
+		assert.True(t, len(runs[dagID]) >= 3, "%s ran %d times", dagID, len(runs[dagID]))
+	}
+	for dagID, dates := range runs {
+		for i := 1; i < len(dates); i++ {
+			assert.True(t, dates[i].After(dates[i-1]), "%s ran twice for %v", dagID, dates[i])
+		}
+	}
+	removedAt := <-removed
+	for _, date := range runs["secondly2"] {
+		assert.True(t, date.Before(removedAt.Add(time.Second)), "removed dag ran at %v", date)
+	}
+}
+
+func TestNextRunAcrossDaylightSaving(t *testing.T) {
+	dag, err := NewDag(&DagConfig{
+		ID:               "daily-new-york",
+		ScheduleInterval: "0 6 * * *",
+		Timezone:         "America/New_York",
+	})
+	assert.Nil(t, err)
+	timetable, err := dag.timetable()
+	assert.Nil(t, err)
+
+	next := timetable.Next(time.Date(2019, 3, 9, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2019, 3, 9, 11, 0, 0, 0, time.UTC), next, "06:00 EST")
+	next = timetable.Next(next)
+	assert.Equal(t, time.Date(2019, 3, 10, 10, 0, 0, 0, time.UTC), next, "06:00 EDT")
+	assert.Equal(t, 6, next.In(dag.Location()).Hour())
+
+	_, err = NewDag(&DagConfig{ID: "bad-zone", Timezone: "Mars/Olympus_Mons"})
+	assert.NotNil(t, err)
+}
+
+func TestNextRunInDaylightSavingGap(t *testing.T) {
+	dag, err := NewDag(&DagConfig{
+		ID:               "gap-new-york",
+		ScheduleInterval: "30 2 * * *",
+		Timezone:         "America/New_York",
+	})
+	assert.Nil(t, err)
+	timetable, err := dag.timetable()
+	assert.Nil(t, err)
+
+	next := timetable.Next(time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2019, 3, 10, 7, 0, 0, 0, time.UTC), next, "02:30 does not exist on 2019-03-10, runs at 03:00 EDT")
+	next = timetable.Next(next)
+	assert.Equal(t, time.Date(2019, 3, 11, 6, 30, 0, 0, time.UTC), next)
+	last := time.Date(2019, 3, 9, 7, 30, 0, 0, time.UTC)
+	next, err = dag.nextRun(last, last.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2019, 3, 10, 7, 0, 0, 0, time.UTC), next)
+
+	dag.ScheduleInterval = "*/30 2 * * *"
+	timetable, err = dag.timetable()
+	assert.Nil(t, err)
+	next = timetable.Next(time.Date(2019, 3, 9, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2019, 3, 10, 7, 0, 0, 0, time.UTC), next)
+	next = timetable.Next(next)
+	assert.Equal(t, time.Date(2019, 3, 11, 6, 0, 0, 0, time.UTC), next, "02:00 and 02:30 in the gap run once")
+
+	dag.ScheduleInterval = "30 1 * * *"
+	timetable, err = dag.timetable()
+	assert.Nil(t, err)
+	next = timetable.Next(time.Date(2019, 11, 3, 4, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2019, 11, 3, 5, 30, 0, 0, time.UTC), next, "01:30 EDT")
+	next = timetable.Next(next)
+	assert.Equal(t, time.Date(2019, 11, 4, 6, 30, 0, 0, time.UTC), next, "01:30 EST of 2019-11-03 repeats the hour")
+}
+
+// weekdays a custom timetable running at midnight UTC on weekdays only
+type weekdays struct{}
+
+func (weekdays) Next(t time.Time) time.Time {
+	next := t.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	for next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func TestNextRunPresets(t *testing.T) {
+	start := time.Date(2019, 1, 4, 0, 0, 0, 0, time.UTC) // friday
+	now := start.Add(50 * time.Hour)
+	tests := []struct {
+		name     string
+		config   DagConfig
+		last     time.Time
+		expected time.Time
+	}{
+		{"daily", DagConfig{ScheduleInterval: "@daily", StartDate: start}, start, start.AddDate(0, 0, 3)},
+		{"daily catchup", DagConfig{ScheduleInterval: "@daily", StartDate: start, Catchup: true}, start, start.AddDate(0, 0, 1)},
+		{"daily first run", DagConfig{ScheduleInterval: "@daily", StartDate: start}, time.Time{}, start},
+		{"hourly", DagConfig{ScheduleInterval: "@hourly", StartDate: start}, start, start.Add(51 * time.Hour)},
+		{"once", DagConfig{ScheduleInterval: "@once", StartDate: start}, time.Time{}, start},
+		{"once ran", DagConfig{ScheduleInterval: "@once", StartDate: start}, start, time.Time{}},
+		{"none", DagConfig{ScheduleInterval: "@none", StartDate: start}, time.Time{}, time.Time{}},
+		{"every", DagConfig{ScheduleInterval: "@every 36h", StartDate: start.Add(time.Hour)}, start.Add(time.Hour), start.Add(73 * time.Hour)},
+		{"every catchup", DagConfig{ScheduleInterval: "@every 36h", StartDate: start.Add(time.Hour), Catchup: true}, start.Add(time.Hour), start.Add(37 * time.Hour)},
+		{"end date", DagConfig{ScheduleInterval: "@daily", StartDate: start, EndDate: start.Add(time.Hour)}, start, time.Time{}},
+		{"timetable", DagConfig{Timetable: weekdays{}, StartDate: start, Catchup: true}, start, start.AddDate(0, 0, 3)},
+	}
+	for _, tt := range tests {
+		tt.config.ID = tt.name
+		dag, err := NewDag(&tt.config)
+		assert.Nil(t, err)
+		next, err := dag.nextRun(tt.last, now)
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.expected, next, tt.name)
+	}
+
+	dag, err := NewDag(&DagConfig{ID: "bad-interval", ScheduleInterval: "@every soon"})
+	assert.Nil(t, err)
+	_, err = dag.NextRun()
+	assert.NotNil(t, err)
+	_, err = (&DAG{ID: "no-start", ScheduleInterval: "@every 1h"}).nextRun(time.Time{}, now)
+	assert.NotNil(t, err, "interval without a start date")
+	for _, interval := range []string{"@every 1h", "@once"} {
+		dag, err := NewDag(&DagConfig{ID: "no-start", ScheduleInterval: interval, Catchup: true})
+		assert.Nil(t, err)
+		assert.True(t, dag.StartDate.IsZero())
+		_, err = dag.nextRun(time.Time{}, now)
+		assert.NotNil(t, err, "%s without a start date", interval)
+	}
+}
+
+func TestNextRunAfterRestart(t *testing.T) {
+	migrate(t)
+	id := "restart-" + time.Now().Format(time.RFC3339Nano)
+	start := time.Now().UTC().Truncate(time.Second).Add(-10*time.Hour + 17*time.Minute)
+	newDag := func(config DagConfig) *DAG { // what a restarted scheduler builds from the same config
+		config.ID = id + config.ScheduleInterval
+		config.StartDate = start
+		dag, err := NewDag(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dag
+	}
+
+	once := newDag(DagConfig{ScheduleInterval: "@once", Catchup: true})
+	next, err := once.NextRun()
+	assert.Nil(t, err)
+	assert.Equal(t, start, next)
+	assert.Nil(t, once.DagRunAt(next).Create())
+	next, err = newDag(DagConfig{ScheduleInterval: "@once", Catchup: true}).NextRun()
+	assert.Nil(t, err)
+	assert.True(t, next.IsZero(), "once ran before the restart, next run %v", next)
+
+	every := newDag(DagConfig{ScheduleInterval: "@every 1h"})
+	assert.Nil(t, every.DagRunAt(start.Add(8*time.Hour)).Create())
+	next, err = newDag(DagConfig{ScheduleInterval: "@every 1h"}).NextRun()
+	assert.Nil(t, err)
+	assert.Equal(t, start.Add(10*time.Hour), next, "keeps the phase of the start date")
+}
+
+// flakyTimetable runs every second but fails to move past a run while failures are left
+type flakyTimetable struct {
+	failures *int
+}
+
+func (f flakyTimetable) Next(t time.Time) time.Time {
+	if *f.failures > 0 {
+		*f.failures--
+		return t
+	}
+	return t.Truncate(time.Second).Add(time.Second)
+}
+
+func TestScheduleRetriesNextRun(t *testing.T) {
+	failures := 0
+	dag, err := NewDag(&DagConfig{ID: "flaky", Timetable: flakyTimetable{&failures}, Catchup: true})
+	assert.Nil(t, err)
+	s := NewScheduler()
+	s.Dags.add(dag)
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.pushNextRun(dag, start)
+
+	failures = 2
+	due, wait := s.dueRuns(start)
+	assert.Len(t, due, 1)
+	assert.Equal(t, time.Second, wait, "first retry")
+	due, wait = s.dueRuns(start.Add(time.Second))
+	assert.Empty(t, due)
+	assert.Equal(t, 2*time.Second, wait, "backs off")
+	due, wait = s.dueRuns(start.Add(3 * time.Second))
+	assert.Equal(t, time.Second, wait, "scheduled again")
+	if assert.Len(t, due, 3, "catches up on the runs missed while failing") {
+		assert.Equal(t, start.Add(time.Second), due[0].executionDate)
+	}
+}
@@ -1,13 +1,16 @@
 package relay
 
 import (
+	"container/heap"
 	"context"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/db"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -22,130 +25,189 @@ func init() {
 
 // Scheduler orchestrates the scheduling of dags
 type Scheduler struct {
-	Dags       map[string]*DAG
-	DagNextRun map[string]time.Time
-	mu         sync.Mutex
+	Dags     *DagBag
+	mu       sync.Mutex
+	nextRuns nextRunHeap
+	wake     chan struct{}
 }
 
 // NewScheduler creates a new scheduler
 func NewScheduler() *Scheduler {
 	return &Scheduler{
-		Dags: map[string]*DAG{},
+		Dags: NewDagBag(),
+		wake: make(chan struct{}, 1),
 	}
 }
 
 // AddDag adds a dag to the scheduler
 // Gets or creates a dag in the database
-func (s *Scheduler) AddDag(dag *DAG) error {
-	_, ok := s.Dags[dag.ID]
-	if ok {
+// Dags can be added while the scheduler runs
+func (s *Scheduler) AddDag(dag *DAG) (err error) {
+	defer func() { recordRegistration(err) }()
+	if err := db.Err(); err != nil {
+		return err
+	}
+	next, err := dag.NextRun()
+	if err != nil {
+		return errors.Wrap(err, "dag next run")
+	}
+	if _, ok := s.Dags.Get(dag.ID); ok {
 		return errors.Errorf("dag: %s allread registered", dag.ID)
 	}
-	if err := dag.getOrCreateDagModel(); err != nil {
+	if err := dag.saveVersion(); err != nil {
 		return err
 	}
-	s.Dags[dag.ID] = dag
-	return nil
-}
-
-func (s *Scheduler) hearbeat(ctx context.Context, ch chan<- string) {
-	ticker := time.NewTicker(time.Duration(config.DefaultConfig.SchedulerHeartBeatSec) * time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			logrus.Infof("scheduler heartbeat")
-			now := time.Now().UTC()
-			for dagID, nextRun := range s.DagNextRun {
-				if now.Equal(nextRun) || now.After(nextRun) {
-					s.Dags[dagID].updateNextScheduled()
-					ch <- dagID
-				}
-			}
-		case <-ctx.Done():
-			logrus.Infof("closing scheduler...")
-			return
-		}
+	if err := dag.getOrCreateDagModel(); err != nil {
+		return err
 	}
-}
-
-func (s *Scheduler) setDagNextRun() error {
-	setDagNextRun := map[string]time.Time{}
-	for dagID, dag := range s.Dags {
-		nextRun, err := dag.NextRun()
-		if err != nil {
-			return errors.Wrap(err, "dag next run")
-		}
-		setDagNextRun[dagID] = nextRun
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.Dags.add(dag) {
+		return errors.Errorf("dag: %s allread registered", dag.ID)
 	}
-	s.DagNextRun = setDagNextRun
+	s.pushNextRun(dag, next)
 	return nil
 }
 
-func (s *Scheduler) updateDagNextRun(dagID string) error {
+// RemoveDag stops scheduling a dag. Runs of the dag that already started keep running
+func (s *Scheduler) RemoveDag(dagID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	dag, ok := s.Dags[dagID]
-	if !ok {
+	if !s.Dags.remove(dagID) {
 		return errors.Errorf("could not find dag: %s", dagID)
 	}
-	nextRun, err := dag.NextRun()
-	if err != nil {
-		return errors.Wrap(err, "dag next run")
+	for i, entry := range s.nextRuns {
+		if entry.dag.ID == dagID {
+			heap.Remove(&s.nextRuns, i)
+			break
+		}
 	}
-	s.DagNextRun[dagID] = nextRun
+	s.wakeUp()
 	return nil
 }
 
 // Run starts the scheduler and dag runner
 // Creates a context that listens for an os.interrupt to terminate running go routines
+// Schedulers sharing a relay database follow the one holding the scheduler lease and take
+// over when its lease expires
 func (s *Scheduler) Run() error {
-	logrus.Infof("starting scheduler heartbeat: %d seconds", config.DefaultConfig.Scheduler.SchedulerHeartBeatSec)
-	if err := s.setDagNextRun(); err != nil {
-		return errors.Wrap(err, "set dag time map")
+	if err := db.Err(); err != nil {
+		return err
+	}
+	executor, err := NewExecutor(config.DefaultConfig.Core.Executor)
+	if err != nil {
+		return errors.Wrap(err, "new executor")
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	job, stopJob, err := startJob(JobScheduler)
+	if err != nil {
+		return err
+	}
+	defer stopJob()
+	stopTelemetry := startTelemetry()
+	defer stopTelemetry()
 
-	webServer := NewWebserver(s.Dags)
-	go webServer.Serve(ctx)
+	killSignal := make(chan os.Signal, 1)
+	signal.Notify(killSignal, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(killSignal)
 
-	dagChan := make(chan string)
+	leader := newLeader(job)
+	if !leader.acquire(killSignal) {
+		return nil
+	}
+	defer leader.release()
+	lostLease := leader.keep()
 
-	go s.hearbeat(ctx, dagChan)
+	s.resetNextRuns()
 
-	dagRunner := NewDagRunner()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	go dagRunner.Run(ctx)
+	dagRunner := NewDagRunner()
+	dagRunner.Executor = executor
+	dagRunner.Job = job
 
+	webServer := NewWebserver(s.Dags)
+	webServer.Runner = dagRunner
+	webServer.Events = events
+	webServer.Shutdown = func() {
+		select {
+		case killSignal <- syscall.SIGTERM:
+		default:
+		}
+	}
 	go func() {
-		<-ctx.Done()
-		close(dagChan)
-		close(dagRunner.Error)
+		if err := webServer.Serve(ctx); err != nil {
+			logrus.Fatal(errors.Wrap(err, "webserver"))
+		}
 	}()
 
-	killSignal := make(chan os.Signal, 1)
-	signal.Notify(killSignal, os.Interrupt)
+	dagChan := make(chan *scheduledRun)
+
+	go s.schedule(ctx, dagChan)
+
+	go dagRunner.Run(ctx)
+	go pushGauges(ctx, s.Dags, dagRunner)
+
+	if err := s.resumeDagRuns(dagRunner); err != nil {
+		return errors.Wrap(err, "resume dag runs")
+	}
+	go s.reapZombieTasks(ctx, dagRunner)
 
 	for {
 		select {
-		case dagID := <-dagChan:
-			if err := s.updateDagNextRun(dagID); err != nil {
-				return errors.Wrap(err, "update next dag run")
-			}
-			dag, ok := s.Dags[dagID]
-			if !ok {
-				return errors.Errorf("could not locate dag: %s", dagID)
-			}
-			dagRunner.RunDag(dag)
+		case run := <-dagChan:
+			dagRunner.ScheduleDag(run.dag, run.executionDate)
 		case err := <-dagRunner.Error:
 			if err != nil {
 				logrus.Error(err)
 			}
-		case <-killSignal:
-			logrus.Infof("kill signal recieved. exiting...")
+		case sig := <-killSignal:
+			logrus.Infof("%s signal recieved. exiting...", sig)
+			s.shutdown(cancel, dagRunner, killSignal)
 			return nil
+		case <-lostLease:
+			logrus.Error("lost the scheduler lease to another scheduler, stopping...")
+			cancel()
+			dagRunner.StopTasks()
+			dagRunner.Wait()
+			return errors.New("lost scheduler lease")
 		}
 	}
 }
+
+// drainer runs tasks that can be waited on or stopped when shutting down
+type drainer interface {
+	Wait()
+	StopTasks()
+}
+
+// shutdown stops scheduling new runs and lets running tasks finish for up to the graceful
+// shutdown period. Tasks still running are then stopped and set up for retry so their dag
+// runs resume when the scheduler starts again. Another signal stops running tasks right away
+func (s *Scheduler) shutdown(cancel context.CancelFunc, tasks drainer, killSignal <-chan os.Signal) {
+	grace := time.Duration(config.DefaultConfig.Scheduler.GracefulShutdownSec) * time.Second
+	logrus.Infof("draining running tasks for up to %v, signal again to stop them now", grace)
+	cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		tasks.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		logrus.Info("running tasks finished")
+		return
+	case <-time.After(grace):
+		logrus.Warn("graceful shutdown period over, stopping running tasks...")
+	case <-killSignal:
+		logrus.Warn("second signal recieved, stopping running tasks...")
+	}
+	tasks.StopTasks()
+	select {
+	case <-drained:
+	case <-time.After(killTimeout + time.Second):
+		logrus.Warn("tasks did not stop, they are handled by the zombie policy on the next start")
+	}
+}
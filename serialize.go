@@ -0,0 +1,221 @@
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+)
+
+// SerializedDag the structure of a dag stored in the relay database, enough for a webserver
+// without the dag's binary to describe and draw it
+type SerializedDag struct {
+	DagID            string            `json:"dagID"`
+	Description      string            `json:"description"`
+	ScheduleInterval string            `json:"scheduleInterval"`
+	Timezone         string            `json:"timezone"`
+	StartDate        time.Time         `json:"startDate"`
+	EndDate          time.Time         `json:"endDate"`
+	Catchup          bool              `json:"catchup"`
+	Concurrency      int               `json:"concurrency"`
+	MaxActiveRuns    int               `json:"maxActiveRuns"`
+	Orientation      string            `json:"orientation"`
+	AccessControl    map[string]string `json:"accessControl,omitempty"`
+	Tasks            []*DagTask        `json:"tasks"`
+}
+
+// paramSerializer operators that describe their key parameters in the serialized dag
+type paramSerializer interface {
+	serializeParams() map[string]interface{}
+}
+
+// serializeTask describes a task and its edges
+func serializeTask(t TaskInterface) *DagTask {
+	task := &DagTask{
+		TaskID:         t.GetID(),
+		Operator:       t.OperatorType(),
+		Pool:           t.GetPool(),
+		PriorityWeight: t.GetPriorityWeight(),
+		WeightRule:     t.GetWeightRule(),
+		Retries:        t.GetRetries(),
+		Upstream:       taskIDs(t.upstreamList()),
+		Downstream:     taskIDs(t.downstreamList()),
+	}
+	if s, ok := t.(paramSerializer); ok {
+		task.Params = s.serializeParams()
+	}
+	return task
+}
+
+// serialize describes the dag as json. The hash identifies the structure and leaves out
+// the start date, which defaults to when the dag was created
+func (d *DAG) serialize() (structure string, hash string, err error) {
+	s := &SerializedDag{
+		DagID:            d.ID,
+		Description:      d.Description,
+		ScheduleInterval: d.ScheduleInterval,
+		Timezone:         d.Location().String(),
+		EndDate:          d.EndDate.UTC(),
+		Catchup:          d.Catchup,
+		Concurrency:      d.Concurrency,
+		MaxActiveRuns:    d.MaxActiveRuns,
+		Orientation:      d.Orientation,
+		AccessControl:    d.AccessControl,
+		Tasks:            []*DagTask{},
+	}
+	for _, taskID := range d.taskIDs() {
+		s.Tasks = append(s.Tasks, serializeTask(d.tasks[taskID]))
+	}
+	unhashed, err := json.Marshal(s)
+	if err != nil {
+		return "", "", errors.Wrap(err, "marshal dag")
+	}
+	sum := sha256.Sum256(unhashed)
+	s.StartDate = d.StartDate.UTC()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", "", errors.Wrap(err, "marshal dag")
+	}
+	return string(b), hex.EncodeToString(sum[:]), nil
+}
+
+// saveVersion stores the structure of the dag as a version of the dag and makes it the
+// version of the dag's next runs
+func (d *DAG) saveVersion() error {
+	structure, hash, err := d.serialize()
+	if err != nil {
+		return err
+	}
+	if _, err := models.SaveDagVersion(d.ID, hash, structure); err != nil {
+		return errors.Wrap(err, "save dag version")
+	}
+	d.hash = hash
+	return nil
+}
+
+// taskIDs the ids of the tasks of the dag, sorted
+func (d *DAG) taskIDs() []string {
+	ids := []string{}
+	for taskID := range d.tasks {
+		ids = append(ids, taskID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// deserializeDag creates a dag from a serialized structure. Its tasks describe the tasks
+// of the dag and cannot be run
+func deserializeDag(structure string) (*DAG, error) {
+	s := &SerializedDag{}
+	if err := json.Unmarshal([]byte(structure), s); err != nil {
+		return nil, errors.Wrap(err, "unmarshal dag")
+	}
+	dag, err := NewDag(&DagConfig{
+		ID:               s.DagID,
+		Description:      s.Description,
+		ScheduleInterval: s.ScheduleInterval,
+		Timezone:         s.Timezone,
+		StartDate:        s.StartDate,
+		EndDate:          s.EndDate,
+		Catchup:          s.Catchup,
+		Concurrency:      s.Concurrency,
+		MaxActiveRuns:    s.MaxActiveRuns,
+		AccessControl:    s.AccessControl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	dag.Orientation = s.Orientation
+	for _, task := range s.Tasks {
+		op := &serializedOperator{task: task, DAG: dag}
+		if err := dag.AddTask(op); err != nil {
+			return nil, errors.Wrap(err, "add task")
+		}
+	}
+	for _, task := range s.Tasks {
+		for _, downstream := range task.Downstream {
+			op, err := dag.getTask(downstream)
+			if err != nil {
+				return nil, errors.Wrapf(err, "downstream of %s", task.TaskID)
+			}
+			dag.tasks[task.TaskID].addDownstreamTask(op.GetID())
+			op.addUpstreamTask(task.TaskID)
+		}
+	}
+	return dag, nil
+}
+
+// serializedOperator a task of a dag loaded from the relay database
+type serializedOperator struct {
+	DAG               *DAG `json:"-"` // avoid recursion
+	task              *DagTask
+	upstreamTaskIDs   []string
+	downstreamTaskIDs []string
+}
+
+func (o *serializedOperator) String() string { return o.task.TaskID }
+
+// GetID returns the tag id for an operator
+func (o *serializedOperator) GetID() string { return o.task.TaskID }
+
+// FormattedID formatted task id
+func (o *serializedOperator) FormattedID() string { return fmt.Sprintf("[TASK] %s", o.task.TaskID) }
+
+func (o *serializedOperator) hasUpstream() bool { return len(o.upstreamTaskIDs) > 0 }
+
+func (o *serializedOperator) downstreamList() []TaskInterface {
+	return taskList(o.DAG, o.downstreamTaskIDs)
+}
+
+func (o *serializedOperator) upstreamList() []TaskInterface {
+	return taskList(o.DAG, o.upstreamTaskIDs)
+}
+
+// GetDag returns the dag for an operator
+func (o *serializedOperator) GetDag() *DAG { return o.DAG }
+
+// SetDag sets the dag on an operator
+func (o *serializedOperator) SetDag(dag *DAG) { o.DAG = dag }
+
+// HasDag checks to see if the operators dag is nil
+func (o *serializedOperator) HasDag() bool { return o.DAG != nil }
+
+func (o *serializedOperator) addDownstreamTask(taskID string) {
+	o.downstreamTaskIDs = append(o.downstreamTaskIDs, taskID)
+}
+
+func (o *serializedOperator) addUpstreamTask(taskID string) {
+	o.upstreamTaskIDs = append(o.upstreamTaskIDs, taskID)
+}
+
+// IsRoot checks if the task has no upstream tasks
+func (o *serializedOperator) IsRoot() bool { return !o.hasUpstream() }
+
+// Run refuses to run, the code of the task is not in this process
+func (o *serializedOperator) Run(ctx context.Context) error {
+	return errors.Errorf("%s was loaded from the relay database and cannot run", o.FormattedID())
+}
+
+// OperatorType type of the operator the task was serialized from
+func (o *serializedOperator) OperatorType() string { return o.task.Operator }
+
+// GetPool pool the task runs in
+func (o *serializedOperator) GetPool() string { return o.task.Pool }
+
+// GetPriorityWeight priority weight of the task
+func (o *serializedOperator) GetPriorityWeight() int { return o.task.PriorityWeight }
+
+// GetWeightRule weight rule of the task
+func (o *serializedOperator) GetWeightRule() WeightRule { return o.task.WeightRule }
+
+// GetRetries number of times the task is retried
+func (o *serializedOperator) GetRetries() int { return o.task.Retries }
+
+// serializeParams the params the task was serialized with
+func (o *serializedOperator) serializeParams() map[string]interface{} { return o.task.Params }
@@ -0,0 +1,64 @@
+package relay
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSerializeDag(t *testing.T) {
+	migrate(t)
+	dag, tasks := newTestDag(t, "t1", "t2", "t3")
+	dag.ID = "serialize-" + time.Now().Format(time.RFC3339Nano)
+	assert.Nil(t, dag.Chain(tasks[0], tasks[1]))
+	_, hash, err := dag.serialize()
+	assert.Nil(t, err)
+
+	dag.StartDate = dag.StartDate.Add(time.Hour)
+	_, same, _ := dag.serialize()
+	assert.Equal(t, hash, same, "start date is not part of the hash")
+	assert.Nil(t, dag.SetDependency("t2", "t3"))
+	_, changed, _ := dag.serialize()
+	assert.NotEqual(t, hash, changed)
+
+	assert.Nil(t, dag.saveVersion())
+	assert.Nil(t, dag.getOrCreateDagModel())
+	assert.Equal(t, changed, dag.DagRun().DagHash)
+
+	dags, err := LoadDagBag()
+	assert.Nil(t, err)
+	loaded, ok := dags.Get(dag.ID)
+	assert.True(t, ok)
+	assert.Equal(t, dag.StartDate.Unix(), loaded.StartDate.Unix())
+	assert.Equal(t, []string{"t1", "t2", "t3"}, loaded.taskIDs())
+	t2 := loaded.tasks["t2"]
+	assert.Equal(t, "bash", t2.OperatorType())
+	assert.Equal(t, []string{"t1"}, taskIDs(t2.upstreamList()))
+	assert.Equal(t, []string{"t3"}, taskIDs(t2.downstreamList()))
+	assert.NotNil(t, t2.Run(context.Background()), "loaded tasks cannot run")
+	_, reloaded, _ := loaded.serialize()
+	assert.Equal(t, changed, reloaded)
+
+	dag.StartDate = dag.StartDate.Add(time.Hour)
+	assert.Nil(t, dag.saveVersion())
+	assert.Nil(t, dags.reload())
+	loaded, _ = dags.Get(dag.ID)
+	assert.Equal(t, dag.StartDate.Unix(), loaded.StartDate.Unix(), "same version takes the new start date")
+	versions, err := models.ListDagVersions(dag.ID)
+	assert.Nil(t, err)
+	assert.Len(t, versions, 1)
+
+	w := NewWebserver(dags)
+	detail := &DagDetail{}
+	assert.Equal(t, http.StatusOK, apiGet(t, w, "/api/v1/dags/"+dag.ID, detail))
+	assert.Equal(t, changed, detail.Hash)
+	assert.Equal(t, "date", detail.Tasks[0].Params["bashCommand"])
+	version := &DagVersionDetail{}
+	assert.Equal(t, http.StatusOK, apiGet(t, w, "/api/v1/dags/"+dag.ID+"/versions/"+changed, version))
+	assert.Len(t, version.Dag.Tasks, 3)
+	assert.Equal(t, http.StatusNotFound, apiGet(t, w, "/api/v1/dags/"+dag.ID+"/versions/"+hash, nil))
+}
@@ -14,3 +14,12 @@ var (
 )
 
 type State string
+
+// Valid checks if a state is one of the known states
+func Valid(s State) bool {
+	switch s {
+	case Success, Running, Failed, Skipped, Rescheduled, Retry, Queued, Pending, UpstreamFailed, None:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+)
+
+var (
+	// streamKeepAlive how often an idle stream sends a comment so proxies keep it open
+	streamKeepAlive = 15 * time.Second
+	// logTailInterval how often a streamed task log is checked for new output
+	logTailInterval = 500 * time.Millisecond
+	// logTailChunk most bytes of a task log sent in one event
+	logTailChunk int64 = 64 << 10
+)
+
+// startStream starts a server-sent event response
+func startStream(c echo.Context) {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+}
+
+// writeEvent writes a server-sent event and flushes it to the client. An empty id leaves
+// the id of the event unset
+func writeEvent(res *echo.Response, event, id string, data []byte) {
+	fmt.Fprintf(res, "event: %s\n", event)
+	if id != "" {
+		fmt.Fprintf(res, "id: %s\n", id)
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(res, "data: %s\n", line)
+	}
+	fmt.Fprint(res, "\n")
+	res.Flush()
+}
+
+// streamRoutes applies the routes streaming events and task logs
+func (w *Webserver) streamRoutes(group *echo.Group) {
+	group.GET("/events", w.streamEvents)
+	group.GET("/dags/:id/runs/:run/tasks/:task/logs/stream", w.streamTaskLog)
+}
+
+// streamEvents streams the dag run and task instance events of the dags the user can see,
+// optionally only those of a dag or dag run
+func (w *Webserver) streamEvents(c echo.Context) error {
+	if w.Events == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "events are only streamed by the webserver of a scheduler")
+	}
+	dagID := c.QueryParam("dag_id")
+	var dagRunID int
+	if v := c.QueryParam("dag_run_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "dag_run_id must be a dag run id")
+		}
+		dagRunID = id
+	}
+	if dagID != "" && !w.canView(c, dagID) {
+		return echo.NewHTTPError(http.StatusNotFound, "dag not found")
+	}
+	sub, unsubscribe := w.Events.Subscribe()
+	defer unsubscribe()
+	startStream(c)
+	res := c.Response()
+	visible := map[string]bool{}
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepAlive.C:
+			fmt.Fprint(res, ": keep-alive\n\n")
+			res.Flush()
+		case e, ok := <-sub:
+			if !ok {
+				return nil
+			}
+			if (dagID != "" && e.DagID != dagID) || (dagRunID != 0 && e.DagRunID != dagRunID) {
+				continue
+			}
+			canView, ok := visible[e.DagID]
+			if !ok {
+				canView = w.canView(c, e.DagID)
+				visible[e.DagID] = canView
+			}
+			if !canView {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			writeEvent(res, e.Type, "", data)
+		}
+	}
+}
+
+// streamTaskLog tails the log of a try of a task instance, the latest by default, from the
+// offset param. Log events carry the offset in the log as their id so clients resume where
+// they left off.
+// An end event with the task instance's state is sent once the try is over and its log is
+// read
+func (w *Webserver) streamTaskLog(c echo.Context) error {
+	dag, dagRun, err := w.lookupDagRunModel(c)
+	if err != nil {
+		return err
+	}
+	ti, err := models.GetTaskInstance(dagRun.ID, c.Param("task"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "task instance not found")
+	}
+	if ti.TryNumber == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "the task has not run yet")
+	}
+	try := ti.TryNumber
+	if v := c.QueryParam("try"); v != "" {
+		try, err = strconv.Atoi(v)
+		if err != nil || try < 1 || try > ti.TryNumber {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("try must be from 1 to %d", ti.TryNumber))
+		}
+	}
+	var offset int64
+	if v := c.Request().Header.Get("Last-Event-ID"); v != "" {
+		offset, _ = strconv.ParseInt(v, 10, 64)
+	} else if v := c.QueryParam("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "offset must be a byte offset in the log")
+		}
+	}
+	path, err := taskLogPath(dag.ID, ti.TaskID, dagRun.ID, try)
+	if err != nil {
+		return err
+	}
+	startStream(c)
+	res := c.Response()
+	ticker := time.NewTicker(logTailInterval)
+	defer ticker.Stop()
+	idle := time.Now()
+	for {
+		// check the try first so the log read after it holds all of its output
+		over := tryOver(dagRun.ID, ti.TaskID, try)
+		for {
+			chunk, err := readLogChunk(path, offset, over != nil)
+			if err != nil {
+				return nil
+			}
+			if len(chunk) == 0 {
+				break
+			}
+			offset += int64(len(chunk))
+			writeEvent(res, "log", strconv.FormatInt(offset, 10), bytes.TrimSuffix(chunk, []byte("\n")))
+			idle = time.Now()
+		}
+		if over != nil {
+			data, _ := json.Marshal(map[string]interface{}{"state": *over, "try": try})
+			writeEvent(res, "end", "", data)
+			return nil
+		}
+		if time.Since(idle) > streamKeepAlive {
+			fmt.Fprint(res, ": keep-alive\n\n")
+			res.Flush()
+			idle = time.Now()
+		}
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryOver gets the state a try of a task instance ended in, or nil while it may still write
+// to its log
+func tryOver(dagRunID int, taskID string, try int) *state.State {
+	ti, err := models.GetTaskInstance(dagRunID, taskID)
+	if err != nil {
+		s := state.None
+		return &s
+	}
+	if ti.TryNumber == try && (ti.State == state.Running || ti.State == state.Queued) {
+		return nil
+	}
+	return &ti.State
+}
+
+// readLogChunk reads the complete lines of a log from offset, or everything left once the
+// log is final. A missing log has nothing to read yet
+func readLogChunk(path string, offset int64, final bool) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(f, logTailChunk))
+	if err != nil {
+		return nil, err
+	}
+	if !final || int64(len(b)) == logTailChunk {
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return b[:i+1], nil
+		} else if int64(len(b)) < logTailChunk {
+			return nil, nil
+		}
+	}
+	return b, nil
+}
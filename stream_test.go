@@ -0,0 +1,192 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+// sseEvent an event read from a server-sent event stream
+type sseEvent struct {
+	event, data string
+}
+
+// openStream gets a server-sent event stream and sends its events until it ends
+func openStream(t *testing.T, ctx context.Context, url string) <-chan *sseEvent {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get(echo.HeaderContentType))
+	out := make(chan *sseEvent, 100)
+	go func() {
+		defer close(out)
+		defer res.Body.Close()
+		readEvents(res.Body, out)
+	}()
+	return out
+}
+
+func readEvents(r io.Reader, out chan<- *sseEvent) {
+	e := &sseEvent{}
+	data := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if e.event != "" {
+				e.data = strings.Join(data, "\n")
+				out <- e
+			}
+			e, data = &sseEvent{}, []string{}
+		case strings.HasPrefix(line, "event: "):
+			e.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	sub, unsubscribe := bus.Subscribe()
+	for i := 0; i < eventBuffer+1; i++ {
+		bus.Publish(&Event{Type: EventDagRun, DagRunID: i})
+	}
+	assert.Equal(t, eventBuffer, len(sub), "events past the buffer dropped")
+	assert.Equal(t, 0, (<-sub).DagRunID)
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(&Event{Type: EventDagRun})
+	assert.Empty(t, bus.subscribers)
+}
+
+func TestEventStream(t *testing.T) {
+	migrate(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dag, tasks := newTestDag(t, "t1", "t2")
+	dag.ID = "events-" + time.Now().Format(time.RFC3339Nano)
+	assert.Nil(t, dag.Chain(tasks...))
+
+	e := echo.New()
+	w := NewWebserver(NewDagBag(dag))
+	w.Routes(e)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "not the scheduler's webserver")
+
+	w.Events = events
+	stream := openStream(t, ctx, server.URL+"/api/v1/events?dag_id="+dag.ID)
+	events.Publish(&Event{Type: EventDagRun, DagID: "other", State: state.Running})
+
+	queue := NewTaskQueue()
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	go func() {
+		assert.Nil(t, dag.Run(ctx, NewTaskRunner(dag.tasks, queue), dagRun))
+	}()
+
+	seen := []string{}
+	for e := range stream {
+		seen = append(seen, e.event+" "+e.data)
+		if e.event == EventDagRun && strings.Contains(e.data, `"state":"success"`) {
+			break
+		}
+	}
+	cancel()
+	all := strings.Join(seen, "\n")
+	assert.NotContains(t, all, `"dagID":"other"`)
+	assert.Contains(t, all, fmt.Sprintf(`dagRun {"type":"dagRun","dagID":%q,"dagRunID":%d,"state":"running"`, dag.ID, dagRun.ID))
+	assert.Contains(t, all, `"taskID":"t1","state":"queued"`)
+	assert.Contains(t, all, `"taskID":"t1","tryNumber":1,"state":"running"`)
+	assert.Contains(t, all, `"taskID":"t1","tryNumber":1,"state":"success"`)
+	assert.Contains(t, all, `"taskID":"t2","tryNumber":1,"state":"success"`)
+}
+
+func TestTaskLogStream(t *testing.T) {
+	migrate(t)
+	logFolder := config.DefaultConfig.Core.BaseLogFolder
+	config.DefaultConfig.Core.BaseLogFolder = filepath.Join(os.TempDir(), fmt.Sprintf("relay-logs-%d", time.Now().UnixNano()))
+	defer func() {
+		os.RemoveAll(config.DefaultConfig.Core.BaseLogFolder)
+		config.DefaultConfig.Core.BaseLogFolder = logFolder
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dag, err := NewDag(&DagConfig{ID: "tail-" + time.Now().Format(time.RFC3339Nano), ScheduleInterval: "@none"})
+	assert.Nil(t, err)
+	script := filepath.Join(config.DefaultConfig.Core.BaseLogFolder, "tail.sh")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(script), 0755))
+	assert.Nil(t, ioutil.WriteFile(script, []byte("echo one\nsleep 1\necho two\n"), 0644))
+	_, err = dag.NewBash(&BashOperator{TaskID: "t1", BashCommand: "sh " + script})
+	assert.Nil(t, err)
+
+	e := echo.New()
+	NewWebserver(NewDagBag(dag)).Routes(e)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	queue := NewTaskQueue()
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	go func() {
+		assert.Nil(t, dag.Run(ctx, NewTaskRunner(dag.tasks, queue), dagRun))
+	}()
+	for {
+		ti, err := models.GetTaskInstance(dagRun.ID, "t1")
+		if err == nil && ti.State == state.Running {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("t1 did not start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	url := fmt.Sprintf("%s/api/v1/dags/%s/runs/%d/tasks/t1/logs/stream", server.URL, dag.ID, dagRun.ID)
+	res, err := http.Get(url + "?try=2")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "try 2 has not run")
+
+	lines := []string{}
+	var end *sseEvent
+	for e := range openStream(t, ctx, url) {
+		if e.event == "log" {
+			lines = append(lines, e.data)
+		}
+		if e.event == "end" {
+			end = e
+		}
+	}
+	log, err := readTaskLog(dag.ID, "t1", dagRun.ID, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.TrimSuffix(string(log), "\n"), strings.Join(lines, "\n"))
+	assert.Contains(t, string(log), "\none\ntwo\n")
+	if assert.NotNil(t, end, "stream ended before the try") {
+		assert.Equal(t, `{"state":"success","try":1}`, end.data)
+	}
+}
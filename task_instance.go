@@ -0,0 +1,99 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/estenssoros/relay/tracing"
+)
+
+// TaskInstance a task in one dag run. It holds the state, model and try number of the
+// task for that run so operators stay immutable definitions shared by every run of a dag.
+// The state is only changed by the task runner of the dag run
+type TaskInstance struct {
+	Task  TaskInterface
+	State state.State
+	Model *models.TaskInstance
+
+	// traceParent the span of the dag run, parent of the spans of the task's tries
+	traceParent tracing.SpanContext
+
+	mu   sync.Mutex
+	stop context.CancelFunc
+	// cancelled the dag run was cancelled before a worker started the task instance
+	cancelled bool
+}
+
+// NewTaskInstance creates a pending task instance of a task
+func NewTaskInstance(task TaskInterface) *TaskInstance {
+	return &TaskInstance{
+		Task:  task,
+		State: state.Pending,
+	}
+}
+
+// FormattedID formatted id of the task along with its dag run
+func (ti *TaskInstance) FormattedID() string {
+	if ti.Model == nil {
+		return ti.Task.FormattedID()
+	}
+	return fmt.Sprintf("%s (run %d)", ti.Task.FormattedID(), ti.Model.DagRunID)
+}
+
+// run runs the task, writing its output to the log of the current try
+func (ti *TaskInstance) run(ctx context.Context) error {
+	if ti.Model == nil {
+		return ti.Task.Run(ctx)
+	}
+	return runLogged(ctx, ti.Task, ti.Model.DagRunID, ti.Model.TryNumber)
+}
+
+// setStop sets the function stopping the task instance while a worker runs it. A task
+// instance cancelled before the worker started it is stopped right away
+func (ti *TaskInstance) setStop(stop context.CancelFunc) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.stop = stop
+	if stop == nil {
+		ti.cancelled = false
+	} else if ti.cancelled {
+		stop()
+	}
+}
+
+// kill stops the task instance if a worker is running it. Its process is terminated the
+// same way as on shutdown. Returns false if it is not running
+func (ti *TaskInstance) kill() bool {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if ti.stop == nil {
+		return false
+	}
+	ti.stop()
+	return true
+}
+
+// cancel kills the task instance if a worker is running it. Otherwise the cancellation is
+// recorded so a worker that has taken it stops it before it runs. Returns false if it is
+// not running
+func (ti *TaskInstance) cancel() bool {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if ti.stop == nil {
+		ti.cancelled = true
+		return false
+	}
+	ti.stop()
+	return true
+}
+
+// TryNumber the current try of the task instance
+func (ti *TaskInstance) TryNumber() int {
+	if ti.Model == nil {
+		return 0
+	}
+	return ti.Model.TryNumber
+}
@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// taskLogKey context key of the writer for the log of a running task
+type taskLogKey struct{}
+
+// taskLog the log writer of the task running with ctx. Operators write their output to it
+func taskLog(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(taskLogKey{}).(io.Writer); ok {
+		return w
+	}
+	return ioutil.Discard
+}
+
+// taskLogPath path of the log of a try of a task instance
+func taskLogPath(dagID, taskID string, dagRunID, try int) (string, error) {
+	folder, err := config.LogFolder()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(folder, dagID, taskID, strconv.Itoa(dagRunID), strconv.Itoa(try)+".log"), nil
+}
+
+// readTaskLog reads the log of a try of a task instance. Logs are written on the host that
+// ran the task
+func readTaskLog(dagID, taskID string, dagRunID, try int) ([]byte, error) {
+	path, err := taskLogPath(dagID, taskID, dagRunID, try)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// runLogged runs a task, writing its output to the log of a try of its task instance
+func runLogged(ctx context.Context, task TaskInterface, dagRunID, try int) error {
+	if !task.HasDag() {
+		return task.Run(ctx)
+	}
+	path, err := taskLogPath(task.GetDag().ID, task.GetID(), dagRunID, try)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		logrus.Error(errors.Wrapf(err, "%s log", task.FormattedID()))
+		return task.Run(ctx)
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%s running %s (run %d) try %d on %s\n", time.Now().UTC().Format(time.RFC3339), task.FormattedID(), dagRunID, try, hostName())
+	err = task.Run(context.WithValue(ctx, taskLogKey{}, io.Writer(f)))
+	if err != nil {
+		fmt.Fprintf(f, "%s failed: %v\n", time.Now().UTC().Format(time.RFC3339), err)
+	} else {
+		fmt.Fprintf(f, "%s success\n", time.Now().UTC().Format(time.RFC3339))
+	}
+	return err
+}
+
+// currentTry the try number of the task instance of a task in a dag run
+func currentTry(dagRunID int, taskID string) int {
+	ti, err := models.GetTaskInstance(dagRunID, taskID)
+	if err != nil {
+		logrus.Error(errors.Wrapf(err, "task instance %s of run %d", taskID, dagRunID))
+		return 0
+	}
+	return ti.TryNumber
+}
+
+// hostName the host name, empty if it can't be read
+func hostName() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,124 @@
+package relay
+
+import (
+	"context"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/metrics"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/estenssoros/relay/tracing"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// startTelemetry sends metrics to statsd and spans to an otlp collector when they are
+// configured. The returned func stops sending after the queued spans are sent
+func startTelemetry() func() {
+	stops := []func(){}
+	if config.DefaultConfig.Metrics.StatsdOn {
+		statsd, err := metrics.NewStatsD(config.StatsdAddr(), config.DefaultConfig.Metrics.StatsdPrefix)
+		if err != nil {
+			logrus.Error(errors.Wrap(err, "statsd"))
+		} else {
+			logrus.Infof("sending metrics to statsd at %s", config.StatsdAddr())
+			metrics.Default.SetSink(statsd)
+			stops = append(stops, func() {
+				metrics.Default.SetSink(nil)
+				statsd.Close()
+			})
+		}
+	}
+	if endpoint := config.DefaultConfig.Traces.OTLPEndpoint; endpoint != "" {
+		logrus.Infof("exporting spans to %s", endpoint)
+		exporter := tracing.NewOTLPExporter(endpoint, config.ServiceName())
+		tracing.SetExporter(exporter)
+		stops = append(stops, func() {
+			tracing.SetExporter(nil)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := exporter.Shutdown(ctx); err != nil {
+				logrus.Error(errors.Wrap(err, "otlp exporter shutdown"))
+			}
+		})
+	}
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// pushGauges sets the gauges read from the dags and dag runner every heartbeat so statsd
+// gets them without a scrape
+func pushGauges(ctx context.Context, dags *DagBag, runner *DagRunner) {
+	if !config.DefaultConfig.Metrics.StatsdOn {
+		return
+	}
+	ticker := time.NewTicker(heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			collectMetrics(dags, runner)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// startDagRunSpan starts the span of a dag run, which the spans of its task tries are
+// children of
+func startDagRunSpan(dagRun *models.DagRun) *tracing.Span {
+	span := tracing.StartSpan("dag_run "+dagRun.DagID, tracing.SpanContext{})
+	span.SetAttribute("dag_id", dagRun.DagID)
+	span.SetAttribute("dag_run_id", dagRun.ID)
+	span.SetAttribute("execution_date", dagRun.ExecutionDate.UTC().Format(time.RFC3339))
+	return span
+}
+
+// startTrySpan starts the span of a try of a task instance as a child of its dag run's span
+func startTrySpan(ti *TaskInstance) *tracing.Span {
+	span := tracing.StartSpan("task "+ti.Task.GetID(), ti.traceParent)
+	span.SetAttribute("dag_id", ti.Task.GetDag().ID)
+	span.SetAttribute("task_id", ti.Task.GetID())
+	span.SetAttribute("try", ti.TryNumber())
+	span.SetAttribute("operator", ti.Task.OperatorType())
+	return span
+}
+
+// traceparent the trace context carried in ctx in the traceparent format, or empty
+func traceparent(ctx context.Context) string {
+	if c := tracing.SpanContextFromContext(ctx); c.IsValid() {
+		return c.Traceparent()
+	}
+	return ""
+}
+
+// contextWithTraceparent carries a trace context in the traceparent format in ctx. Empty
+// or malformed trace contexts are left out
+func contextWithTraceparent(ctx context.Context, s string) context.Context {
+	if s == "" {
+		return ctx
+	}
+	c, err := tracing.ParseTraceparent(s)
+	if err != nil {
+		logrus.Warn(err)
+		return ctx
+	}
+	return tracing.ContextWithSpanContext(ctx, c)
+}
+
+// finishSpan ends the span of a dag run or try with its final state. Failed spans are
+// errors with the message, or the state when there is none
+func finishSpan(span *tracing.Span, s state.State, message string) {
+	span.SetAttribute("state", string(s))
+	if s == state.Failed {
+		if message == "" {
+			message = string(s)
+		}
+		span.SetError(errors.New(message))
+	}
+	span.Finish()
+}
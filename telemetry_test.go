@@ -0,0 +1,116 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceDagRun(t *testing.T) {
+	migrate(t)
+	logFolder := config.DefaultConfig.Core.BaseLogFolder
+	config.DefaultConfig.Core.BaseLogFolder = filepath.Join(os.TempDir(), fmt.Sprintf("relay-logs-%d", time.Now().UnixNano()))
+	defer func() {
+		os.RemoveAll(config.DefaultConfig.Core.BaseLogFolder)
+		config.DefaultConfig.Core.BaseLogFolder = logFolder
+	}()
+	exporter := &tracing.InMemoryExporter{}
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dag, err := NewDag(&DagConfig{ID: "traced-" + time.Now().Format(time.RFC3339Nano), ScheduleInterval: "@none"})
+	assert.Nil(t, err)
+	script := filepath.Join(config.DefaultConfig.Core.BaseLogFolder, "traceparent.sh")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(script), 0755))
+	assert.Nil(t, ioutil.WriteFile(script, []byte("echo traceparent=$TRACEPARENT\n"), 0644))
+	t1, _ := dag.NewBash(&BashOperator{TaskID: "t1", BashCommand: "sh " + script})
+	t2, _ := dag.NewBash(&BashOperator{TaskID: "t2", BashCommand: "false"})
+	assert.Nil(t, dag.Chain(t1, t2))
+
+	queue := NewTaskQueue()
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	assert.Nil(t, dag.Run(ctx, NewTaskRunner(dag.tasks, queue), dagRun))
+
+	spans := exporter.Spans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	first, second, run := spans[0], spans[1], spans[2]
+	assert.Equal(t, "dag_run "+dag.ID, run.Name)
+	assert.Equal(t, dag.ID, run.Attributes["dag_id"])
+	assert.Equal(t, dagRun.ID, run.Attributes["dag_run_id"])
+	assert.Equal(t, "failed", run.Attributes["state"])
+	assert.False(t, run.Parent.IsValid())
+
+	assert.Equal(t, "task t1", first.Name)
+	assert.Equal(t, map[string]interface{}{
+		"dag_id":   dag.ID,
+		"task_id":  "t1",
+		"try":      1,
+		"operator": "bash",
+		"state":    "success",
+	}, first.Attributes)
+	assert.Equal(t, run.Context, first.Parent)
+	assert.Empty(t, first.Error)
+	assert.Equal(t, "task t2", second.Name)
+	assert.Equal(t, run.Context, second.Parent)
+	assert.Equal(t, "failed", second.Attributes["state"])
+	assert.NotEmpty(t, second.Error)
+
+	log, err := readTaskLog(dag.ID, "t1", dagRun.ID, 1)
+	assert.Nil(t, err)
+	assert.Contains(t, string(log), "traceparent="+first.Context.Traceparent(), "trace context passed to the bash command")
+}
+
+func TestTraceFailedBeforeRun(t *testing.T) {
+	migrate(t)
+	exporter := &tracing.InMemoryExporter{}
+	tracing.SetExporter(exporter)
+	defer tracing.SetExporter(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dag, err := NewDag(&DagConfig{ID: "traced-failed-" + time.Now().Format(time.RFC3339Nano), ScheduleInterval: "@none"})
+	assert.Nil(t, err)
+	_, err = dag.NewBash(&BashOperator{TaskID: "t1", BashCommand: "true", Pool: "missing"})
+	assert.Nil(t, err)
+	queue := NewTaskQueue()
+	go NewWorker(&localExecutor{}).Start(ctx, ctx, queue)
+	dagRun := dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	assert.Nil(t, dag.Run(ctx, NewTaskRunner(dag.tasks, queue), dagRun))
+
+	spans := exporter.Spans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	assert.Equal(t, "task t1", spans[0].Name)
+	assert.Equal(t, "failed", spans[0].Attributes["state"])
+	assert.Contains(t, spans[0].Error, "pool missing does not exist")
+	assert.Equal(t, spans[1].Context, spans[0].Parent)
+
+	runner := NewTaskRunner(dag.tasks, queue)
+	runner.Tasks["t1"].Task = nil
+	dagRun = dag.DagRun()
+	assert.Nil(t, dagRun.Create())
+	assert.NotNil(t, dag.Run(ctx, runner, dagRun))
+	spans = exporter.Spans()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	assert.Equal(t, "dag_run "+dag.ID, spans[2].Name)
+	assert.Equal(t, "failed", spans[2].Attributes["state"], "not left running")
+	assert.NotEmpty(t, spans[2].Error)
+}
@@ -0,0 +1,186 @@
+package relay
+
+import (
+	"strings"
+	"time"
+
+	"github.com/estenssoros/relay/models"
+	"github.com/gorhill/cronexpr"
+	"github.com/pkg/errors"
+)
+
+// Schedule presets besides the cron presets @yearly, @monthly, @weekly, @daily and @hourly.
+// A schedule interval of "@every <duration>", like "@every 90m", runs at fixed intervals
+// from the dag start date
+const (
+	ScheduleOnce  string = "@once"
+	ScheduleNone  string = "@none"
+	scheduleEvery string = "@every "
+)
+
+// Timetable decides when a dag runs. Set DAG.Timetable for schedules a cron expression
+// can't describe, like business days only or a holiday calendar
+type Timetable interface {
+	// Next returns the first run time after t, or a zero time if there are no more runs
+	Next(t time.Time) time.Time
+}
+
+// cronTimetable runs on a cron expression evaluated in a time zone. The expression is
+// evaluated on wall clock times. Like cron, runs whose wall clock time falls in a daylight
+// saving gap run when the gap ends, and runs in a repeated hour only run once
+type cronTimetable struct {
+	expr     *cronexpr.Expression
+	location *time.Location
+}
+
+func (c cronTimetable) Next(t time.Time) time.Time {
+	wall := wallClock(t.In(c.location))
+	for {
+		wall = c.expr.Next(wall)
+		if wall.IsZero() {
+			return wall
+		}
+		next := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), c.location)
+		if !wallClock(next).Equal(wall) {
+			next = gapEnd(next)
+		}
+		if next.After(t) {
+			return next.UTC()
+		}
+	}
+}
+
+// wallClock the wall clock time of t as a UTC time, which has no daylight saving jumps
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// gapEnd the instant clocks sprang forward near t, where time.Date puts a wall clock time
+// that falls in the gap. Gaps are at most a few hours long
+func gapEnd(t time.Time) time.Time {
+	lo, hi := t.Add(-3*time.Hour), t.Add(3*time.Hour)
+	_, offset := hi.Zone()
+	if _, loOffset := lo.Zone(); loOffset == offset {
+		return t
+	}
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if _, midOffset := mid.Zone(); midOffset == offset {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi.Truncate(time.Second)
+}
+
+// intervalTimetable runs every fixed interval from a start date
+type intervalTimetable struct {
+	start time.Time
+	every time.Duration
+}
+
+func (i intervalTimetable) Next(t time.Time) time.Time {
+	if t.Before(i.start) {
+		return i.start
+	}
+	return i.start.Add((t.Sub(i.start)/i.every + 1) * i.every)
+}
+
+// onceTimetable runs once at a start date
+type onceTimetable struct {
+	at time.Time
+}
+
+func (o onceTimetable) Next(t time.Time) time.Time {
+	if t.Before(o.at) {
+		return o.at
+	}
+	return time.Time{}
+}
+
+// noneTimetable never runs. Dags without a schedule only run when triggered
+type noneTimetable struct{}
+
+func (noneTimetable) Next(t time.Time) time.Time { return time.Time{} }
+
+// timetable returns the dag's Timetable, or one parsed from its schedule interval
+func (d *DAG) timetable() (Timetable, error) {
+	if d.Timetable != nil {
+		return d.Timetable, nil
+	}
+	interval := strings.TrimSpace(d.ScheduleInterval)
+	switch {
+	case interval == ScheduleNone:
+		return noneTimetable{}, nil
+	case interval == ScheduleOnce:
+		if d.StartDate.IsZero() {
+			return nil, errors.Errorf("once needs a start date: %s", interval)
+		}
+		return onceTimetable{at: d.StartDate.UTC()}, nil
+	case strings.HasPrefix(interval, scheduleEvery):
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(interval, scheduleEvery)))
+		if err != nil {
+			return nil, errors.Wrap(err, "parse interval")
+		}
+		if every <= 0 {
+			return nil, errors.Errorf("interval must be positive: %s", interval)
+		}
+		if d.StartDate.IsZero() {
+			return nil, errors.Errorf("interval needs a start date: %s", interval)
+		}
+		return intervalTimetable{start: d.StartDate.UTC(), every: every}, nil
+	}
+	expr, err := cronexpr.Parse(interval)
+	if err != nil {
+		return nil, errors.Wrap(err, "cron parse")
+	}
+	return cronTimetable{expr: expr, location: d.Location()}, nil
+}
+
+// nextRun returns the run following the last run of the dag, which is zero if the dag has
+// not run yet. Runs missed before now are skipped unless the dag catches up, except the
+// first run of a dag that has not run yet. Returns a zero time if the dag never runs again
+func (d *DAG) nextRun(last, now time.Time) (time.Time, error) {
+	timetable, err := d.timetable()
+	if err != nil {
+		return time.Time{}, err
+	}
+	from := last
+	if from.IsZero() {
+		from = now
+		if !d.StartDate.IsZero() {
+			from = d.StartDate.Add(-time.Nanosecond)
+		}
+	}
+	next := timetable.Next(from)
+	if !d.Catchup && !last.IsZero() && next.Before(now) {
+		from = now
+		next = timetable.Next(from)
+	}
+	if !next.IsZero() && !next.After(from) {
+		return time.Time{}, errors.Errorf("timetable run %v is not after %v", next, from)
+	}
+	if !d.EndDate.IsZero() && next.After(d.EndDate) {
+		return time.Time{}, nil
+	}
+	return next, nil
+}
+
+// NextRun returns the next run of the dag after its last run
+func (d *DAG) NextRun() (time.Time, error) {
+	last, err := d.lastExecutionDate()
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "last execution date")
+	}
+	return d.nextRun(last, time.Now().UTC())
+}
+
+// lastExecutionDate the execution date of the latest run of the dag. Zero if it has not run
+func (d *DAG) lastExecutionDate() (time.Time, error) {
+	dagRuns, err := models.ListDagRuns(d.ID, 1)
+	if err != nil || len(dagRuns) == 0 {
+		return time.Time{}, err
+	}
+	return dagRuns[0].ExecutionDate.UTC(), nil
+}
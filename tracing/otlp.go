@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// otlpBatchSize most spans sent in one request
+	otlpBatchSize = 100
+	// otlpFlushInterval how long finished spans wait to be sent with others
+	otlpFlushInterval = 5 * time.Second
+)
+
+// OTLPExporter sends finished spans in batches to an OpenTelemetry collector with the
+// OTLP/HTTP json encoding. Spans are dropped when the collector falls behind
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+	spans       chan *Span
+	stop        chan chan struct{}
+}
+
+// NewOTLPExporter creates an exporter sending to the collector at endpoint, such as
+// http://localhost:4318, and starts sending
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	e := &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan *Span, 10*otlpBatchSize),
+		stop:        make(chan chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// ExportSpan queues a span to be sent
+func (e *OTLPExporter) ExportSpan(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		logrus.Warnf("otlp exporter behind, dropped span %s", s.Name)
+	}
+}
+
+// Shutdown sends the queued spans and stops the exporter
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case e.stop <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) loop() {
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := []*Span{}
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			logrus.Error(errors.Wrapf(err, "send %d spans", len(batch)))
+		}
+		batch = []*Span{}
+	}
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-e.stop:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			flush()
+			close(done)
+			return
+		}
+	}
+}
+
+// send posts a batch of spans to the collector
+func (e *OTLPExporter) send(spans []*Span) error {
+	b, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		return errors.Wrap(err, "marshal spans")
+	}
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "post spans")
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return errors.Errorf("collector responded %s", res.Status)
+	}
+	return nil
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpValue       `json:"status"`
+}
+
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+// encode builds the OTLP/HTTP json request of a batch of spans
+func (e *OTLPExporter) encode(spans []*Span) map[string]interface{} {
+	encoded := []*otlpSpan{}
+	for _, s := range spans {
+		span := &otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpValue{"code": otlpStatusOK},
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = hex.EncodeToString(s.Parent.SpanID[:])
+		}
+		if s.Error != "" {
+			span.Status = otlpValue{"code": otlpStatusError, "message": s.Error}
+		}
+		encoded = append(encoded, span)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/estenssoros/relay"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// otlpAttributes encodes attributes sorted by key. 64 bit ints are strings in OTLP json
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	keys := []string{}
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	encoded := []otlpAttribute{}
+	for _, k := range keys {
+		var v otlpValue
+		switch value := attrs[k].(type) {
+		case string:
+			v = otlpValue{"stringValue": value}
+		case bool:
+			v = otlpValue{"boolValue": value}
+		case int:
+			v = otlpValue{"intValue": strconv.Itoa(value)}
+		case int64:
+			v = otlpValue{"intValue": strconv.FormatInt(value, 10)}
+		case float64:
+			v = otlpValue{"doubleValue": value}
+		default:
+			continue
+		}
+		encoded = append(encoded, otlpAttribute{Key: k, Value: v})
+	}
+	return encoded
+}
@@ -0,0 +1,217 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EnvTraceparent environment variable child processes get the trace context of the span
+// that started them in, in the w3c traceparent format
+const EnvTraceparent = "TRACEPARENT"
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span of a trace
+type SpanID [8]byte
+
+// SpanContext the ids that place a span in its trace
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid checks if the span context has both ids
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+// Traceparent the span context in the w3c traceparent format
+func (c SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(c.TraceID[:]), hex.EncodeToString(c.SpanID[:]))
+}
+
+// ParseTraceparent parses a span context in the w3c traceparent format
+func ParseTraceparent(s string) (SpanContext, error) {
+	c := SpanContext{}
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return c, errors.Errorf("malformed traceparent %q", s)
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil || len(parts[1]) != 32 {
+		return c, errors.Errorf("malformed trace id in %q", s)
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil || len(parts[2]) != 16 {
+		return c, errors.Errorf("malformed span id in %q", s)
+	}
+	if !c.IsValid() {
+		return c, errors.Errorf("invalid traceparent %q", s)
+	}
+	return c, nil
+}
+
+// Span a timed operation of a trace
+type Span struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      string
+	mu         sync.Mutex
+	ended      bool
+	exporter   Exporter
+}
+
+// SetAttribute sets an attribute of the span. Values are strings, bools, ints or floats
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed with err
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// SpanContext the span context of the span, or an invalid one for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Finish ends the span and exports it. Later calls do nothing
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	s.exporter.ExportSpan(s)
+}
+
+// Exporter receives finished spans
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+var (
+	mu       sync.Mutex
+	exporter Exporter
+)
+
+// SetExporter sends finished spans to e. Spans are only recorded while an exporter is set
+func SetExporter(e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporter = e
+}
+
+// Enabled checks if spans are recorded
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return exporter != nil
+}
+
+// StartSpan starts a span as a child of parent, or of a new trace when parent is invalid.
+// Returns nil when no exporter is set, which is safe to use
+func StartSpan(name string, parent SpanContext) *Span {
+	mu.Lock()
+	e := exporter
+	mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	s := &Span{
+		Name:       name,
+		Parent:     parent,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		exporter:   e,
+	}
+	s.Context.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		rand.Read(s.Context.TraceID[:])
+		s.Parent = SpanContext{}
+	}
+	rand.Read(s.Context.SpanID[:])
+	return s
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext carries a span context in ctx. Invalid span contexts are left out
+func ContextWithSpanContext(ctx context.Context, c SpanContext) context.Context {
+	if !c.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, c)
+}
+
+// SpanContextFromContext gets the span context carried in ctx
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	c, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return c
+}
+
+// Env the environment variable passing the span context carried in ctx to a child process,
+// or nil when ctx carries none
+func Env(ctx context.Context) []string {
+	if c := SpanContextFromContext(ctx); c.IsValid() {
+		return []string{EnvTraceparent + "=" + c.Traceparent()}
+	}
+	return nil
+}
+
+// InMemoryExporter keeps finished spans in memory, for tests
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan keeps a span
+func (e *InMemoryExporter) ExportSpan(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+// Spans the finished spans, in the order they finished
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span{}, e.spans...)
+}
+
+// Reset drops the kept spans
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceparent(t *testing.T) {
+	c, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.Nil(t, err)
+	assert.True(t, c.IsValid())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", c.Traceparent())
+
+	for _, s := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.NotNil(t, err, s)
+	}
+
+	ctx := ContextWithSpanContext(context.Background(), c)
+	assert.Equal(t, []string{"TRACEPARENT=" + c.Traceparent()}, Env(ctx))
+	assert.Nil(t, Env(context.Background()))
+}
+
+func TestStartSpan(t *testing.T) {
+	assert.Nil(t, StartSpan("off", SpanContext{}), "no exporter")
+	var span *Span
+	span.SetAttribute("safe", true)
+	span.Finish()
+
+	exporter := &InMemoryExporter{}
+	SetExporter(exporter)
+	defer SetExporter(nil)
+	root := StartSpan("root", SpanContext{})
+	child := StartSpan("child", root.SpanContext())
+	child.SetAttribute("try", 1)
+	child.SetError(errors.New("boom"))
+	child.Finish()
+	child.Finish()
+	root.Finish()
+
+	spans := exporter.Spans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, root.Context.TraceID, spans[0].Context.TraceID)
+		assert.Equal(t, root.Context, spans[0].Parent)
+		assert.NotEqual(t, root.Context.SpanID, spans[0].Context.SpanID)
+		assert.Equal(t, 1, spans[0].Attributes["try"])
+		assert.Equal(t, "boom", spans[0].Error)
+		assert.False(t, spans[1].Parent.IsValid())
+	}
+	exporter.Reset()
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		b, _ := ioutil.ReadAll(r.Body)
+		req := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(b, &req), string(b))
+		requests <- req
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/", "relay-test")
+	SetExporter(exporter)
+	defer SetExporter(nil)
+	root := StartSpan("dag_run etl", SpanContext{})
+	child := StartSpan("task extract", root.SpanContext())
+	child.SetAttribute("task_id", "extract")
+	child.SetAttribute("try", 2)
+	child.SetError(errors.New("exit status 1"))
+	child.Finish()
+	assert.Nil(t, exporter.Shutdown(context.Background()))
+
+	req := <-requests
+	resourceSpans := req["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resource, _ := json.Marshal(resourceSpans["resource"])
+	assert.JSONEq(t, `{"attributes":[{"key":"service.name","value":{"stringValue":"relay-test"}}]}`, string(resource))
+	span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "task extract", span["name"])
+	assert.Equal(t, child.Context.Traceparent()[3:35], span["traceId"])
+	assert.Equal(t, root.Context.Traceparent()[36:52], span["parentSpanId"])
+	attributes, _ := json.Marshal(span["attributes"])
+	assert.JSONEq(t, `[{"key":"task_id","value":{"stringValue":"extract"}},{"key":"try","value":{"intValue":"2"}}]`, string(attributes))
+	status, _ := json.Marshal(span["status"])
+	assert.JSONEq(t, `{"code":2,"message":"exit status 1"}`, string(status))
+}
@@ -1,68 +1,287 @@
 package relay
 
 import (
-	"bytes"
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
-	"path/filepath"
+	"os"
 	"strconv"
-	"text/template"
+	"strings"
+	"time"
 
 	"github.com/estenssoros/relay/config"
 	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/metrics"
 	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/leaanthony/mewn"
 	"github.com/leaanthony/mewn/lib"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+// dagBagReload how often a standalone webserver reloads its dags from the relay database
+var dagBagReload = 10 * time.Second
+
 // Webserver handles the webserver
 type Webserver struct {
-	Dags map[string]*DAG
+	Dags   *DagBag
+	Runner *DagRunner
+	// Events streamed to clients, only set in the scheduler's webserver
+	Events *EventBus
+	// Shutdown stops the scheduler the same way SIGTERM does
+	Shutdown func()
 }
 
-// NewWebserver creates a new webserver from a dag map
-func NewWebserver(dags map[string]*DAG) *Webserver {
-	return &Webserver{dags}
+// NewWebserver creates a new webserver from a dag bag
+func NewWebserver(dags *DagBag) *Webserver {
+	return &Webserver{Dags: dags}
 }
 
-// Routes applies routes to echo
+// Routes applies routes to echo. Errors are returned as json. When authentication is on
+// requests need a user with a role allowing the route
 func (w *Webserver) Routes(c *echo.Echo) {
+	c.HTTPErrorHandler = httpErrorHandler
+	c.Use(w.authenticate)
+	w.authRoutes(c)
+	c.POST("/api/kill", func(c echo.Context) error {
+		if user, ok := c.Get(userKey).(*models.User); !ok || user.Role != models.RoleAdmin {
+			if err := authorizeSecret(c); err != nil {
+				return err
+			}
+		}
+		if w.Shutdown == nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "scheduler not running")
+		}
+		logrus.Infof("shutdown requested from %s", c.RealIP())
+		w.Shutdown()
+		return c.JSON(http.StatusAccepted, "shutting down")
+	})
+	c.GET("/health", func(c echo.Context) error {
+		health := CheckHealth(w.Runner, 0)
+		if health.Status != Healthy {
+			return c.JSON(http.StatusServiceUnavailable, health)
+		}
+		return c.JSON(http.StatusOK, health)
+	})
+	c.GET("/metrics", func(c echo.Context) error {
+		collectMetrics(w.Dags, w.Runner)
+		c.Response().Header().Set(echo.HeaderContentType, metrics.ContentType)
+		return metrics.Default.Write(c.Response())
+	})
+	v1 := c.Group("/api/v1")
+	v1.Use(w.requireRole(models.RoleViewer))
+	w.v1Routes(v1)
+	w.streamRoutes(v1)
 	group := c.Group("/api")
+	group.Use(w.requireRole(models.RoleViewer))
 	group.GET("/dags", func(c echo.Context) error {
 		conn := db.Connection
+		dagModels := []*models.DAG{}
+		if err := conn.Find(&dagModels).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
 		dags := []*models.DAG{}
-		if err := conn.Find(&dags).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+		for _, dagModel := range dagModels {
+			if w.canView(c, dagModel.ID) {
+				dags = append(dags, dagModel)
+			}
 		}
 		return c.JSON(http.StatusOK, dags)
 	})
-	group.POST("/kill", func(c echo.Context) error {
-		ctx := c.Get("appContext").(context.Context)
-		ctx, cancel := context.WithCancel(ctx)
-		cancel()
-		fmt.Println("cancelled!")
-		return c.JSON(http.StatusOK, "killed")
-	})
 	group.POST("/dag-toggle", func(c echo.Context) error {
 		req := &struct {
 			DagID  string `json:"dagID"`
 			Paused bool   `json:"paused"`
 		}{}
 		if err := c.Bind(req); err != nil {
-			return c.JSON(http.StatusBadRequest, err)
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
-		dag := &models.DAG{ID: req.DagID}
-		if err := db.Connection.Model(dag).Update("IsPaused", req.Paused).Error; err != nil {
-			return c.JSON(http.StatusInternalServerError, err)
+		dag, _ := w.Dags.Get(req.DagID)
+		if err := authorize(c, dag, models.RoleOperator); err != nil {
+			return err
+		}
+		if err := models.SetDagPaused(req.DagID, req.Paused); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
 		return nil
 	})
+	group.GET("/status", func(c echo.Context) error {
+		if w.Runner == nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "dag runner not started")
+		}
+		statuses := []*DagStatus{}
+		for _, dag := range w.Dags.List() {
+			if w.canView(c, dag.ID) {
+				statuses = append(statuses, w.Runner.Status(dag))
+			}
+		}
+		return c.JSON(http.StatusOK, statuses)
+	})
+	group.GET("/dags/:id/runs", func(c echo.Context) error {
+		dag, ok := w.Dags.Get(c.Param("id"))
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, "dag not found")
+		}
+		limit := 25
+		if l := c.QueryParam("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+				return echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive number")
+			}
+		}
+		dagRuns, err := models.ListDagRuns(dag.ID, limit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		for i, dagRun := range dagRuns {
+			dagRuns[i] = dagRun.In(dag.Location())
+		}
+		return c.JSON(http.StatusOK, dagRuns)
+	})
+	group.POST("/dags/:id/runs/:run/clear", func(c echo.Context) error {
+		req := &struct {
+			Task       string `json:"task"`
+			Downstream bool   `json:"downstream"`
+			Upstream   bool   `json:"upstream"`
+		}{}
+		if err := c.Bind(req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		dag, dagRun, err := w.lookupDagRun(c)
+		if err != nil {
+			return err
+		}
+		var cleared []*models.TaskInstance
+		err = w.Runner.Rerun(dag, dagRun, func() (err error) {
+			if cleared, err = dag.Clear(dagRun, req.Task, req.Downstream, req.Upstream); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			return nil
+		})
+		if _, ok := err.(*echo.HTTPError); ok {
+			return err
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return c.JSON(http.StatusOK, cleared)
+	}, w.requireRole(models.RoleOperator))
+	group.POST("/dags/:id/runs/:run/cancel", func(c echo.Context) error {
+		_, dagRun, err := w.lookupDagRun(c)
+		if err != nil {
+			return err
+		}
+		if err := w.Runner.Cancel(dagRun); err != nil {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return c.JSON(http.StatusAccepted, dagRun)
+	}, w.requireRole(models.RoleOperator))
+	group.POST("/dags/:id/runs/:run/tasks/:task/kill", func(c echo.Context) error {
+		_, dagRun, err := w.lookupDagRun(c)
+		if err != nil {
+			return err
+		}
+		if err := w.Runner.Kill(dagRun, c.Param("task")); err != nil {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return c.NoContent(http.StatusAccepted)
+	}, w.requireRole(models.RoleOperator))
+	group.POST("/dags/:id/runs/:run/tasks/:task/mark", func(c echo.Context) error {
+		req := &struct {
+			State      state.State `json:"state"`
+			Downstream bool        `json:"downstream"`
+			Upstream   bool        `json:"upstream"`
+			Note       string      `json:"note"`
+		}{}
+		if err := c.Bind(req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		dag, dagRun, err := w.lookupDagRun(c)
+		if err != nil {
+			return err
+		}
+		taskIDs, err := dag.selectTasks(c.Param("task"), req.Downstream, req.Upstream)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		marked, err := w.Runner.Mark(dag, dagRun, taskIDs, req.State, req.Note)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return c.JSON(http.StatusOK, marked)
+	}, w.requireRole(models.RoleOperator))
+	group.GET("/pools", func(c echo.Context) error {
+		pools, err := models.ListPools()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, pools)
+	})
+	group.POST("/pools", func(c echo.Context) error {
+		req := &struct {
+			Pool        string `json:"pool"`
+			Slots       int    `json:"slots"`
+			Description string `json:"description"`
+		}{}
+		if err := c.Bind(req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if req.Pool == "" || req.Slots < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "pool name and non negative slots required")
+		}
+		pool, err := models.SetPool(req.Pool, req.Slots, req.Description)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusOK, pool)
+	}, w.requireRole(models.RoleAdmin))
+	group.DELETE("/pools/:pool", func(c echo.Context) error {
+		if err := models.DeletePool(c.Param("pool")); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	}, w.requireRole(models.RoleAdmin))
+}
+
+// authorizeSecret checks the request has the webserver secret key as its bearer token.
+// Requests are refused when no secret key is configured
+func authorizeSecret(c echo.Context) error {
+	secret := config.DefaultConfig.Webserver.SecretKey
+	if secret == "" {
+		return echo.NewHTTPError(http.StatusForbidden, "set secret_key in the webserver config to allow this")
+	}
+	token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid secret key")
+	}
+	return nil
+}
+
+// lookupDagRun gets the dag and dag run from the id and run path params
+func (w *Webserver) lookupDagRun(c echo.Context) (*DAG, *models.DagRun, error) {
+	if w.Runner == nil {
+		return nil, nil, echo.NewHTTPError(http.StatusServiceUnavailable, "dag runner not started")
+	}
+	dag, ok := w.Dags.Get(c.Param("id"))
+	if !ok {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "dag not found")
+	}
+	runID, err := strconv.Atoi(c.Param("run"))
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "run must be a dag run id")
+	}
+	dagRun, err := models.GetDagRun(runID)
+	if err != nil || dagRun.DagID != dag.ID {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "dag run not found")
+	}
+	return dag, dagRun, nil
 }
 
 type mewnFileServer struct {
@@ -73,26 +292,38 @@ func (fs *mewnFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	fmt.Println(r.URL.String())
 }
 
-// Serve serves the web endopoints
+// Serve serves the web endopoints on the webserver config host and port until ctx is done.
+// It serves https when ssl_cert and ssl_key are set and writes its access and error logs
+// to access_logfile and error_logfile
 func (w *Webserver) Serve(ctx context.Context) error {
+	cfg := config.DefaultConfig.Webserver
+	if (cfg.SSLCert == "") != (cfg.SSLKey == "") {
+		return errors.New("ssl_cert and ssl_key must be set together")
+	}
+	accessLog, err := openServerLog(cfg.AccessLogFile)
+	if err != nil {
+		return errors.Wrap(err, "access log")
+	}
+	defer accessLog.Close()
+	errorLog, err := openServerLog(cfg.ErrorLogFile)
+	if err != nil {
+		return errors.Wrap(err, "error log")
+	}
+	defer errorLog.Close()
+
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.HideBanner = true
+	e.Logger.SetOutput(errorLog)
+	e.StdLogger = log.New(errorLog, "echo: ", log.LstdFlags)
+	e.Server.ReadTimeout = time.Duration(cfg.WorkerTimeout) * time.Second
+	e.TLSServer.ReadTimeout = e.Server.ReadTimeout
+	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{Output: accessLog}))
 	e.Use(middleware.Recover())
-	// templateGroup := mewn.Group("./templates")
-	// t := &Template{
-	// 	templates: templateGroup,
-	// }
-	// e.Renderer = t
-	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			c.Set("appContext", ctx)
-			return next(c)
-		}
-	})
 
 	w.Routes(e)
 
 	templateGroup := mewn.Group("./templates")
+	w.Pages(e, templateGroup)
 	e.GET("/", func(e echo.Context) error {
 		fileStr := templateGroup.String("index.html")
 		return e.Blob(http.StatusOK, "text/html", []byte(fileStr))
@@ -103,52 +334,61 @@ func (w *Webserver) Serve(ctx context.Context) error {
 		return e.Blob(http.StatusOK, "image/ico", []byte(fileStr))
 	})
 
-	e.GET("/public/:fileName", func(e echo.Context) error {
-		var fileContentType string
-
-		fileStr := templateGroup.String(e.Param("fileName"))
-
-		switch filepath.Ext(e.Param("fileName")) {
-		case ".css":
-			fileContentType = "text/css"
-		default:
-			reader := bytes.NewReader([]byte(fileStr))
-			fileHeader := make([]byte, 512)
-			reader.Read(fileHeader)
-			fileContentType = http.DetectContentType(fileHeader)
-		}
-		return e.Blob(http.StatusOK, fileContentType, []byte(fileStr))
-	})
-
-	c := make(chan error)
+	address := net.JoinHostPort(cfg.Host, strconv.FormatInt(cfg.Port, 10))
+	c := make(chan error, 1)
 	go func() {
-		err := e.Start(":" + strconv.FormatInt(config.DefaultConfig.Webserver.Port, 10))
-		if err != nil {
-			c <- err
+		if cfg.SSLCert != "" {
+			c <- e.StartTLS(address, cfg.SSLCert, cfg.SSLKey)
+			return
 		}
-		close(c)
+		c <- e.Start(address)
 	}()
-	for {
-		select {
-		case err := <-c:
-			log.Fatal(err)
-		case <-ctx.Done():
-			return nil
-		}
+	select {
+	case err := <-c:
+		return errors.Wrap(err, "start webserver")
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return e.Shutdown(shutdownCtx)
+	}
+}
+
+// openServerLog opens a webserver log file for appending. An empty path or - is stderr
+func openServerLog(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stderr}, nil
 	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
 }
 
-// Template holdes templates
-type Template struct {
-	templates *lib.FileGroup
+// nopCloser a writer that is not closed with the webserver
+type nopCloser struct {
+	io.Writer
 }
 
-// Render implements the echo render function
-func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	ts := t.templates.String(name)
-	if ts == "" {
-		return errors.Errorf("missing template: %s", name)
+func (nopCloser) Close() error { return nil }
+
+// ServeStandalone serves the webserver from the relay database without a scheduler. Dags
+// are loaded from the database and reloaded every dagBagReload. Requests that need the dag
+// runner of a scheduler are answered with 503
+func ServeStandalone(ctx context.Context) error {
+	dags, err := LoadDagBag()
+	if err != nil {
+		return errors.Wrap(err, "load dags")
 	}
-	temp := template.Must(template.New(name).Parse(ts))
-	return temp.ExecuteTemplate(w, name, data)
+	go func() {
+		ticker := time.NewTicker(dagBagReload)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := dags.reload(); err != nil {
+					logrus.Error(errors.Wrap(err, "reload dags"))
+				}
+			}
+		}
+	}()
+	return NewWebserver(dags).Serve(ctx)
 }
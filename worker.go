@@ -3,50 +3,91 @@ package relay
 import (
 	"context"
 
+	"github.com/estenssoros/relay/models"
 	"github.com/estenssoros/relay/state"
+	"github.com/estenssoros/relay/tracing"
 	"github.com/sirupsen/logrus"
 )
 
 // Worker multiprocessing unit that performs the actual task
 type Worker struct {
-	name string
-	kill chan struct{}
+	name     string
+	executor Executor
+	job      *models.Job
 }
 
-// NewWorker creates a new worker with a clever name
-func NewWorker() *Worker {
+// NewWorker creates a new worker with a clever name that runs tasks with an executor
+func NewWorker(executor Executor) *Worker {
 	return &Worker{
-		name: namer.randomName(),
-		kill: make(chan struct{}),
+		name:     namer.randomName(),
+		executor: executor,
 	}
 }
 
-// Start starts a worker workin
-func (w *Worker) Start(ctx context.Context, taskQueue <-chan TaskInterface, evalQueue chan<- TaskInterface) {
+// Start starts a worker workin. Workers pull task instances from the shared task queue
+// until ctx is cancelled, store the result on the task instance model and send the task
+// instance back to the eval queue of its task runner. Tasks run with taskCtx so running
+// tasks can finish after ctx is cancelled. Tasks interrupted by taskCtx are set up for retry
+// and tasks that are killed fail
+func (w *Worker) Start(ctx, taskCtx context.Context, queue *TaskQueue) {
 	logrus.Debugf("starter worker %s", w.name)
+	workerSlots.Add(1)
 	defer func() {
+		workerSlots.Add(-1)
 		logrus.Debugf("worker %s exited", w.name)
 	}()
 	for {
-		select {
-		case task := <-taskQueue:
-			task.SetState(state.Running)
-			logrus.Infof("%s running %s", w.name, task.FormattedID())
-			err := task.Run()
-			if err != nil {
-				logrus.Errorf("%s failed", task.FormattedID())
-				task.GetModel().Message = err.Error()
-				task.SetState(state.Failed)
-			} else {
-				logrus.Infof("%s success", task.FormattedID())
-				task.SetState(state.Success)
-			}
-			evalQueue <- task
-		case <-w.kill:
-			logrus.Infof("killing worker %s...", w.name)
-			return
-		case <-ctx.Done():
+		item, err := queue.Pop(ctx)
+		if err != nil {
 			return
 		}
+		ti := item.ti
+		if item.err != nil {
+			logrus.Errorf("%s failed: %v", ti.FormattedID(), item.err)
+			span := startTrySpan(ti)
+			ti.Model.Message = item.err.Error()
+			ti.Model.State = state.Failed
+			ti.Model.Stop()
+			finishSpan(span, ti.Model.State, ti.Model.Message)
+			item.evalQueue <- ti
+			continue
+		}
+		if w.job != nil {
+			ti.Model.SetJob(w.job)
+		}
+		ti.Model.Start()
+		publishTaskInstance(ti, state.Running)
+		logrus.Infof("%s running %s try %d", w.name, ti.FormattedID(), ti.TryNumber())
+		span := startTrySpan(ti)
+		runCtx, stop := context.WithCancel(tracing.ContextWithSpanContext(taskCtx, span.SpanContext()))
+		ti.setStop(stop)
+		workersBusy.Add(1)
+		if err = runCtx.Err(); err == nil { // not cancelled before it started
+			err = w.executor.Execute(runCtx, ti)
+		}
+		workersBusy.Add(-1)
+		ti.setStop(nil)
+		killed := runCtx.Err() != nil && taskCtx.Err() == nil
+		stop()
+		queue.Done(item)
+		if err != nil && taskCtx.Err() != nil {
+			logrus.Warnf("%s interrupted", ti.FormattedID())
+			ti.Model.Message = "interrupted by shutdown"
+			ti.Model.State = state.Retry
+		} else if err != nil && killed {
+			logrus.Warnf("%s killed", ti.FormattedID())
+			ti.Model.Message = "killed"
+			ti.Model.State = state.Failed
+		} else if err != nil {
+			logrus.Errorf("%s failed", ti.FormattedID())
+			ti.Model.Message = err.Error()
+			ti.Model.State = state.Failed
+		} else {
+			logrus.Infof("%s success", ti.FormattedID())
+			ti.Model.State = state.Success
+		}
+		ti.Model.Stop()
+		finishSpan(span, ti.Model.State, ti.Model.Message)
+		item.evalQueue <- ti
 	}
 }
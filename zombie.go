@@ -0,0 +1,158 @@
+package relay
+
+import (
+	"context"
+	"time"
+
+	"github.com/estenssoros/relay/config"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ZombiePolicy what to do with task instances left running by a process that stopped
+type ZombiePolicy string
+
+const (
+	// ZombieFail fails zombie task instances
+	ZombieFail ZombiePolicy = "fail"
+	// ZombieRetry runs zombie task instances again if they have tries left, otherwise fails them
+	ZombieRetry ZombiePolicy = "retry"
+	// ZombieResume runs zombie task instances again
+	ZombieResume ZombiePolicy = "resume"
+)
+
+// zombiePolicy the zombie policy set in the config
+func zombiePolicy() ZombiePolicy {
+	switch policy := ZombiePolicy(config.DefaultConfig.Scheduler.ZombiePolicy); policy {
+	case ZombieFail, ZombieRetry, ZombieResume:
+		return policy
+	case "":
+		return ZombieRetry
+	default:
+		logrus.Warnf("unknown zombie policy %s, using %s", policy, ZombieRetry)
+		return ZombieRetry
+	}
+}
+
+// zombieFails checks if the zombie policy fails a task instance instead of running it again
+func zombieFails(taskModel *models.TaskInstance, policy ZombiePolicy) bool {
+	maxTries := taskModel.MaxTries
+	if maxTries == 0 {
+		maxTries = 1
+	}
+	return policy == ZombieFail || (policy == ZombieRetry && taskModel.TryNumber >= maxTries)
+}
+
+// reapZombie applies the zombie policy to a task instance left running by a process that
+// stopped. Task instances that were queued never started and are always run again
+func reapZombie(taskModel *models.TaskInstance, policy ZombiePolicy) error {
+	if taskModel.State == state.Queued {
+		return taskModel.Clear()
+	}
+	if zombieFails(taskModel, policy) {
+		return taskModel.Fail("zombie: process stopped while the task was running")
+	}
+	logrus.Infof("zombie task %s of dag run %d will run again", taskModel.TaskID, taskModel.DagRunID)
+	return taskModel.Clear()
+}
+
+// reapZombies applies the zombie policy to the task instances of a dag run left queued or
+// running by a scheduler that stopped
+func (d *DAG) reapZombies(dagRun *models.DagRun, policy ZombiePolicy) error {
+	taskModels, err := d.taskModels(dagRun)
+	if err != nil {
+		return errors.Wrap(err, "task models")
+	}
+	for _, taskModel := range taskModels {
+		switch taskModel.State {
+		case state.Running, state.Queued:
+			if err := reapZombie(taskModel, policy); err != nil {
+				return errors.Wrapf(err, "reap %s", taskModel.TaskID)
+			}
+		}
+	}
+	return nil
+}
+
+// resumeDagRuns continues dag runs left running or queued in the relay database by a
+// scheduler that stopped. Zombie task instances are handled by the zombie policy and
+// every other task keeps its stored state
+func (s *Scheduler) resumeDagRuns(runner *DagRunner) error {
+	dagRuns, err := models.ActiveDagRuns()
+	if err != nil {
+		return errors.Wrap(err, "active dag runs")
+	}
+	policy := zombiePolicy()
+	for _, dagRun := range dagRuns {
+		dag, ok := s.Dags.Get(dagRun.DagID)
+		if !ok {
+			logrus.Warnf("cannot resume dag run %d: dag %s is not registered", dagRun.ID, dagRun.DagID)
+			continue
+		}
+		if err := dag.reapZombies(dagRun, policy); err != nil {
+			return errors.Wrapf(err, "%s reap zombies", dag.FormattedID())
+		}
+		logrus.Infof("resuming %s run %d", dag.FormattedID(), dagRun.ID)
+		if err := runner.Rerun(dag, dagRun, nil); err != nil {
+			logrus.Warnf("cannot resume %s run %d: %v", dag.FormattedID(), dagRun.ID, err)
+		}
+	}
+	return nil
+}
+
+// reapZombieTasks periodically applies the zombie policy to task instances left running by
+// a job that stopped heartbeating. Task instances of dag runs this scheduler is running are
+// left to their executor. Other dag runs with zombies are resumed
+func (s *Scheduler) reapZombieTasks(ctx context.Context, runner *DagRunner) {
+	ticker := time.NewTicker(heartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.reapZombieTaskInstances(runner); err != nil {
+				logrus.Error(errors.Wrap(err, "reap zombie tasks"))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Scheduler) reapZombieTaskInstances(runner *DagRunner) error {
+	zombies, err := models.ZombieTaskInstances(heartbeatTimeout())
+	if err != nil {
+		return errors.Wrap(err, "zombie task instances")
+	}
+	policy := zombiePolicy()
+	resume := map[int]*models.DagRun{}
+	for _, taskModel := range zombies {
+		dagRun, err := models.GetDagRun(taskModel.DagRunID)
+		if err != nil {
+			return errors.Wrapf(err, "dag run %d", taskModel.DagRunID)
+		}
+		if runner.IsActive(dagRun) {
+			continue
+		}
+		logrus.Warnf("zombie task %s of dag run %d: job %d stopped heartbeating", taskModel.TaskID, dagRun.ID, taskModel.JobID)
+		if err := reapZombie(taskModel, policy); err != nil {
+			return errors.Wrapf(err, "reap %s", taskModel.TaskID)
+		}
+		switch dagRun.State {
+		case state.Running, state.Queued:
+			resume[dagRun.ID] = dagRun
+		}
+	}
+	for _, dagRun := range resume {
+		dag, ok := s.Dags.Get(dagRun.DagID)
+		if !ok {
+			continue
+		}
+		logrus.Infof("resuming %s run %d", dag.FormattedID(), dagRun.ID)
+		if err := runner.Rerun(dag, dagRun, nil); err != nil {
+			logrus.Warnf("cannot resume %s run %d: %v", dag.FormattedID(), dagRun.ID, err)
+		}
+	}
+	return nil
+}
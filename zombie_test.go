@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/estenssoros/relay/db"
+	"github.com/estenssoros/relay/models"
+	"github.com/estenssoros/relay/state"
+	"github.com/stretchr/testify/assert"
+)
+
+var reapZombieTests = []struct {
+	policy    ZombiePolicy
+	from      state.State
+	tryNumber int
+	maxTries  int
+	expected  state.State
+}{
+	{ZombieFail, state.Running, 1, 3, state.Failed},
+	{ZombieFail, state.Queued, 0, 1, state.None},
+	{ZombieRetry, state.Running, 1, 3, state.None},
+	{ZombieRetry, state.Running, 3, 3, state.Failed},
+	{ZombieRetry, state.Running, 1, 0, state.Failed},
+	{ZombieResume, state.Running, 3, 3, state.None},
+}
+
+func TestReapZombie(t *testing.T) {
+	migrate(t)
+	for _, tt := range reapZombieTests {
+		taskModel := &models.TaskInstance{TaskID: "zombie", State: tt.from, TryNumber: tt.tryNumber, MaxTries: tt.maxTries}
+		assert.Nil(t, taskModel.Create())
+		assert.Nil(t, reapZombie(taskModel, tt.policy))
+		assert.Equal(t, tt.expected, taskModel.State, "%s %s", tt.policy, tt.from)
+	}
+}
+
+func TestReapZombieTaskInstances(t *testing.T) {
+	migrate(t)
+	alive, err := models.NewJob(JobWorker)
+	assert.Nil(t, err)
+	stale, err := models.NewJob(JobWorker)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Connection.Model(stale).Update("latest_heartbeat", time.Now().UTC().Add(-2*heartbeatTimeout())).Error)
+	ended, err := models.NewJob(JobWorker)
+	assert.Nil(t, err)
+	assert.Nil(t, ended.End())
+
+	dag, _ := newTestDag(t, "t1")
+	dagRun := dag.DagRun()
+	dagRun.State = state.Failed
+	assert.Nil(t, dagRun.Create())
+	taskModels := map[*models.Job]*models.TaskInstance{}
+	for _, job := range []*models.Job{alive, stale, ended} {
+		taskModel := &models.TaskInstance{TaskID: "t1", DagRunID: dagRun.ID, State: state.Running, TryNumber: 1, MaxTries: 1}
+		taskModel.SetJob(job)
+		assert.Nil(t, taskModel.Create())
+		taskModels[job] = taskModel
+	}
+
+	s := NewScheduler()
+	s.Dags.add(dag)
+	assert.Nil(t, s.reapZombieTaskInstances(NewDagRunner()))
+	for job, expected := range map[*models.Job]state.State{alive: state.Running, stale: state.Failed, ended: state.Failed} {
+		taskModel := &models.TaskInstance{}
+		assert.Nil(t, db.Connection.First(taskModel, taskModels[job].ID).Error)
+		assert.Equal(t, expected, taskModel.State)
+		assert.Equal(t, job.HostName, taskModel.HostName)
+		assert.Equal(t, job.PID, taskModel.PID)
+	}
+}